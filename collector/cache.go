@@ -0,0 +1,139 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sync"
+	"time"
+)
+
+var cacheAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(ExporterName, "", "datasource_cache_age_seconds"),
+	"seconds since the cached metrics of the datasource were collected",
+	[]string{"collect", "datasource"}, nil,
+)
+
+// metricCache holds the metrics of the last collection of a datasource that is collected in the background.
+type metricCache struct {
+	mux       sync.RWMutex
+	metrics   []prometheus.Metric
+	timestamp time.Time
+	// closed when the first collection is completed
+	ready chan struct{}
+	once  sync.Once
+}
+
+func newMetricCache() *metricCache {
+	return &metricCache{ready: make(chan struct{})}
+}
+
+func (mc *metricCache) set(metrics []prometheus.Metric, timestamp time.Time) {
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	mc.metrics, mc.timestamp = metrics, timestamp
+	mc.once.Do(func() { close(mc.ready) })
+}
+
+func (mc *metricCache) collected() bool {
+	mc.mux.RLock()
+	defer mc.mux.RUnlock()
+	return !mc.timestamp.IsZero()
+}
+
+// get returns the cached metrics, it waits for the first collection if it is not completed yet, so that
+// concurrent scrapes do not collect the datasource again.
+func (mc *metricCache) get(ctx context.Context) ([]prometheus.Metric, time.Time, bool) {
+	select {
+	case <-mc.ready:
+	case <-ctx.Done():
+		return nil, time.Time{}, false
+	}
+	mc.mux.RLock()
+	defer mc.mux.RUnlock()
+	return mc.metrics, mc.timestamp, true
+}
+
+// StartScheduledCollect starts collecting the datasources that have an interval (or whose collect has an interval)
+// in the background, until the context is done.
+func (c *CollectConfig) StartScheduledCollect(ctx context.Context) {
+	for _, ds := range c.Datasource {
+		interval := ds.Interval
+		if interval == 0 {
+			interval = c.Interval
+		}
+		if ds.ReadMode == Stream || interval <= 0 || (ds.AllowReplace && c.discovery != nil) {
+			continue
+		}
+		ds.cache = newMetricCache()
+		go func(ds *Datasource) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				c.refreshCache(ctx, ds)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(ds)
+	}
+}
+
+// refreshCache collects the datasource and replaces the cached metrics. If the collection failed, the previous
+// metrics are kept, and the age of the cache shows that they are stale.
+func (c *CollectConfig) refreshCache(ctx context.Context, ds *Datasource) {
+	mgs := NewMetricGenerators(10, c.logger)
+	var err error
+	go func() {
+		err = c.GetMetricByDs(ctx, c.logger, ds, mgs.metrics)
+		close(mgs.metrics)
+	}()
+	ch := make(chan prometheus.Metric, 10)
+	go func() {
+		mgs.Collect(ch)
+		close(ch)
+	}()
+	now := time.Now()
+	var metrics []prometheus.Metric
+	for metric := range ch {
+		var m dto.Metric
+		if e := metric.Write(&m); e == nil && m.TimestampMs == nil {
+			metric = prometheus.NewMetricWithTimestamp(now, metric)
+		}
+		metrics = append(metrics, metric)
+	}
+	if err != nil {
+		if ds.cache.collected() || ctx.Err() != nil {
+			level.Warn(c.logger).Log("msg", "failed to refresh the cache of datasource, keep the previous metrics", "datasource", ds.Name, "err", err)
+			return
+		}
+	}
+	ds.cache.set(metrics, now)
+}
+
+func (c *CollectContext) collectCache(ds *Datasource, proMetrics chan<- prometheus.Metric) {
+	metrics, timestamp, ok := ds.cache.get(c.Context)
+	if !ok {
+		return
+	}
+	for _, metric := range metrics {
+		proMetrics <- metric
+	}
+	proMetrics <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(timestamp).Seconds(), c.Name, ds.Name)
+}
@@ -27,6 +27,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 )
 
 const ExporterName string = "data_exporter"
@@ -43,10 +44,23 @@ var (
 		Name:      "collect_error_count",
 		Help:      "datasource or metric collect error count",
 	}, []string{"type", "name"})
+	streamSeries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "stream_series",
+		Help:      "number of series tracked by stream collect",
+	}, []string{"collect"})
+	streamSeriesEvicted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "stream_series_evicted_total",
+		Help:      "number of series deleted from stream collect because of ttl or max_series",
+	}, []string{"collect", "reason"})
 )
 
 func RegisterCollector(reg prometheus.Registerer) {
-	reg.MustRegister(collectErrorCount)
+	reg.MustRegister(collectErrorCount, execExitCode, execStderrBytes, streamSeries, streamSeriesEvicted,
+		datasourceScrapeDuration, datasourceSuccess, datasourceLastSuccess, datasourceBytesRead, datasourceLinesRead,
+		datasourceDatapointsParsed, datasourceSamplesEmitted, datasourceStreamReconnects, datasourceStreamOffset, duplicateSeries,
+		websocketConnections, websocketConnectAttempts, websocketMessagesReceived, websocketKeepaliveTimeouts)
 }
 
 const (
@@ -54,16 +68,36 @@ const (
 	Json  DataFormat = "json"
 	Xml   DataFormat = "xml"
 	Yaml  DataFormat = "yaml"
+	Csv   DataFormat = "csv"
+	Tsv   DataFormat = "tsv"
+
+	Prometheus DataFormat = "prometheus"
 )
 
 type CollectConfig struct {
 	Name           string         `yaml:"name,omitempty"`
 	RelabelConfigs RelabelConfigs `yaml:"relabel_configs,omitempty"`
 	DataFormat     DataFormat     `yaml:"data_format"`
+	CSV            *CSVConfig     `yaml:"csv,omitempty"`
 	Datasource     []*Datasource  `yaml:"datasource"`
 	Metrics        MetricConfigs  `yaml:"metrics"`
-	logger         log.Logger
-	metrics        MetricGroup
+	// Interval is the interval at which the datasources are collected in the background, the scrapes are served
+	// from the last result. 0 means that the datasources are collected on every scrape.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// StaticConfigs, FileSDConfigs and HTTPSDConfigs discover the targets read by the datasources that allow
+	// replace, the labels of each target are added to its datapoints.
+	StaticConfigs []*TargetGroup  `yaml:"static_configs,omitempty"`
+	FileSDConfigs []*FileSDConfig `yaml:"file_sd_configs,omitempty"`
+	HTTPSDConfigs []*HTTPSDConfig `yaml:"http_sd_configs,omitempty"`
+	// TargetParallelism is the maximum number of targets collected concurrently.
+	TargetParallelism int `yaml:"target_parallelism,omitempty"`
+	// Push enables the push endpoint of the collect.
+	Push      *PushConfig `yaml:"push,omitempty"`
+	logger    log.Logger
+	metrics   *MetricGroup
+	discovery *targetDiscovery
+	// metricsKept is set if the series of the collect are kept by the collect of the next configuration.
+	metricsKept bool
 }
 
 func regexCompile(regexStr string, require bool, point string) (*regexp.Regexp, error) {
@@ -97,6 +131,22 @@ func (c *CollectConfig) UnmarshalYAML(value *yaml.Node) error {
 		return err
 	} else {
 		c.DataFormat = c.DataFormat.ToLower()
+		if c.DataFormat == Csv || c.DataFormat == Tsv {
+			if c.CSV == nil {
+				c.CSV = &CSVConfig{}
+			}
+			if c.DataFormat == Tsv && len(c.CSV.Delimiter) == 0 {
+				c.CSV.Delimiter = "\t"
+			}
+			for _, ds := range c.Datasource {
+				if ds.ReadMode != Full && len(c.CSV.Columns) == 0 {
+					return fmt.Errorf("csv columns is required when the read_mode of datasource is %s", ds.ReadMode)
+				} else if ds.ReadMode != Full && (c.CSV.Header || c.CSV.SkipRows > 0) {
+					// each line is parsed on its own, it would be taken as the header or skipped
+					return fmt.Errorf("csv header and skip_rows are not supported when the read_mode of datasource is %s", ds.ReadMode)
+				}
+			}
+		}
 		for i := range c.Metrics {
 			pointPrefix := fmt.Sprintf("Collect.Metrics[%d].Match", i)
 			if c.DataFormat == Regex {
@@ -108,29 +158,101 @@ func (c *CollectConfig) UnmarshalYAML(value *yaml.Node) error {
 				if err = c.Metrics[i].BuildTemplate(pointPrefix); err != nil {
 					return err
 				}
+			} else if c.DataFormat == Prometheus {
+				if err = c.Metrics[i].BuildPrometheusMatch(pointPrefix); err != nil {
+					return err
+				}
+			}
+		}
+		if c.Interval < 0 {
+			return fmt.Errorf("interval cannot be negative: %s", c.Interval)
+		}
+		for _, ds := range c.Datasource {
+			ds.collect = c.Name
+		}
+		for _, mc := range c.Metrics {
+			mc.collect = c.Name
+		}
+		if c.TargetParallelism == 0 {
+			c.TargetParallelism = DefaultTargetParallelism
+		} else if c.TargetParallelism < 0 {
+			return fmt.Errorf("target_parallelism cannot be negative: %d", c.TargetParallelism)
+		}
+		if c.hasDiscovery() {
+			hasTargetDs := false
+			for _, ds := range c.Datasource {
+				hasTargetDs = hasTargetDs || (ds.AllowReplace && ds.ReadMode != Stream)
+			}
+			if !hasTargetDs {
+				return fmt.Errorf("discovered targets of collect %q are not used: no datasource allows replace", c.Name)
+			}
+			for _, group := range c.StaticConfigs {
+				if err = group.verify(); err != nil {
+					return fmt.Errorf("invalid static_configs: %s", err)
+				}
+			}
+			c.discovery = &targetDiscovery{groups: map[string][]*TargetGroup{}}
+			if len(c.StaticConfigs) > 0 {
+				c.discovery.set(c.Name+"/static", c.StaticConfigs)
 			}
 		}
-		c.metrics.metrics = make(map[string]prometheus.Collector)
+		c.metrics = &MetricGroup{name: c.Name, metrics: make(map[string]prometheus.Collector)}
 	}
 	return nil
 }
 
+// streamExpireInterval is the interval at which idle series of stream collect are deleted.
+var streamExpireInterval = time.Second * 10
+
+// streamRetryInterval is the initial delay before re-establishing a stream, it is doubled after each attempt that
+// fails or reads nothing, up to streamMaxRetryInterval.
+var (
+	streamRetryInterval    = time.Second
+	streamMaxRetryInterval = time.Minute
+)
+
 type ContextKey string
 
 var LoggerContextName ContextKey = "_logger_"
 
-func (c *CollectConfig) GetMetricByDs(ctx context.Context, logger log.Logger, ds *Datasource, metrics chan<- MetricGenerator) {
+// LastEventIDContextName is the key of the id of the last event read from a Server-Sent Events stream, it is sent in
+// the Last-Event-ID header when the stream is re-established.
+var LastEventIDContextName ContextKey = "_last_event_id_"
+
+// GetMetricByDs reads the datasource and sends the metrics to the channel. The returned error has already been logged.
+func (c *CollectConfig) GetMetricByDs(ctx context.Context, logger log.Logger, ds *Datasource, metrics chan<- MetricGenerator) error {
+	start := time.Now()
+	err := c.getMetricByDs(ctx, logger, ds, metrics)
+	observeScrape(c.Name, ds.Name, start, err)
+	return err
+}
+
+// getMetricByDs is GetMetricByDs without recording the duration and the result of the read.
+func (c *CollectConfig) getMetricByDs(ctx context.Context, logger log.Logger, ds *Datasource, metrics chan<- MetricGenerator) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			collectErrorCount.WithLabelValues("datasource", ds.Name).Inc()
 			level.Error(logger).Log("msg", "Failed to get metrics from datasource.", "err", r)
+			err = fmt.Errorf("%v", r)
 		}
 	}()
+	if files, ok, err := ds.globFiles(); ok {
+		if err != nil {
+			collectErrorCount.WithLabelValues("datasource", ds.Name).Inc()
+			level.Error(c.logger).Log("msg", "Failed to match files.", "err", err, "datasource", ds.Name)
+		}
+		for _, file := range files {
+			if e := c.getMetricByDs(ctx, logger, ds.withFile(file), metrics); e != nil {
+				err = e
+			}
+		}
+		return err
+	}
 	ctx, cancel := context.WithTimeout(ctx, ds.Timeout)
 	defer cancel()
 	logger = log.With(c.logger, "datasource", ds.Name)
 	ctx = context.WithValue(ctx, LoggerContextName, logger)
-	rcs := append(c.RelabelConfigs, ds.RelabelConfigs...)
+	rcs := c.relabelConfigs(ds)
 	if ds.ReadMode == Line {
 		err := func() error {
 			stream, err := ds.GetLineStream(ctx, logger)
@@ -147,12 +269,14 @@ func (c *CollectConfig) GetMetricByDs(ctx context.Context, logger log.Logger, ds
 					}
 					return nil
 				}
-				c.GetMetric(logger, line, rcs, metrics)
+				datapoints, samples := c.GetMetric(logger, line, rcs, metrics)
+				observeData(c.Name, ds.Name, len(line), 1, datapoints, samples)
 			}
 		}()
 		if err != nil {
 			collectErrorCount.WithLabelValues("datasource", ds.Name).Inc()
 			level.Error(logger).Log("msg", "Failed to read data.", "err", err)
+			return err
 		}
 	} else if ds.ReadMode == Stream {
 	} else if ds.ReadMode == Full {
@@ -160,46 +284,82 @@ func (c *CollectConfig) GetMetricByDs(ctx context.Context, logger log.Logger, ds
 		if err != nil {
 			collectErrorCount.WithLabelValues("datasource", ds.Name).Inc()
 			level.Error(c.logger).Log("msg", "Failed to get datasource.", "err", err)
-			return
+			return err
 		}
-		c.GetMetric(logger, data, rcs, metrics)
+		datapoints, samples := c.GetMetric(logger, data, rcs, metrics)
+		observeData(c.Name, ds.Name, len(data), 0, datapoints, samples)
 	}
+	return nil
 }
-func (c *CollectConfig) GetMetric(logger log.Logger, data []byte, rcs RelabelConfigs, metrics chan<- MetricGenerator) {
+
+// relabelConfigs returns the relabel_configs applied to the data of the datasource: the implicit relabel_configs of the
+// datasource, then the relabel_configs of the collect and of the datasource.
+func (c *CollectConfig) relabelConfigs(ds *Datasource) RelabelConfigs {
+	rcs := make(RelabelConfigs, 0, len(ds.implicitRelabelConfigs)+len(c.RelabelConfigs)+len(ds.RelabelConfigs))
+	rcs = append(rcs, ds.implicitRelabelConfigs...)
+	rcs = append(rcs, c.RelabelConfigs...)
+	return append(rcs, ds.RelabelConfigs...)
+}
+
+// GetMetric sends the metrics of the data to the channel, and returns the number of datapoints matched and of metrics sent.
+func (c *CollectConfig) GetMetric(logger log.Logger, data []byte, rcs RelabelConfigs, metrics chan<- MetricGenerator) (datapoints, samples int) {
 	var err error
 	for _, mc := range c.Metrics {
-		var dps []Datapoint
-		rcs = append(rcs, mc.RelabelConfigs...)
-		metricLogger := log.With(logger, "metric", mc.Name)
-		level.Debug(metricLogger).Log("title", "Raw Data", "data_format", c.DataFormat, "data", string(wrapper.Limit[byte](data, 256, wrapper.PosCenter, []byte(" ... ")...)))
-		switch c.DataFormat.ToLower() {
-		case Regex:
-			dps = mc.GetDatapointsByRegex(metricLogger, data)
-		case Json:
-			dps = mc.GetDatapointsByJson(metricLogger, data)
-		case Xml:
-			dps = mc.GetDatapointsByXml(metricLogger, data)
-		case Yaml:
-			dps = mc.GetDatapointsByYaml(metricLogger, data)
-		}
-
+		// the relabel_configs of a metric must not be applied to the next metrics
+		metricRcs := append(rcs[:len(rcs):len(rcs)], mc.RelabelConfigs...)
+		dps := c.getDatapoints(logger, mc, data)
+		datapoints += len(dps)
 		for _, dp := range dps {
-			m := MetricGenerator{
-				logger:     logger,
-				MetricType: mc.MetricType,
-				Name:       mc.Name,
-				Labels:     Labels{Label{Name: "name", Value: mc.Name}},
-			}
-			for name, val := range dp {
-				m.Labels.Append(name, val)
-			}
-			m.Labels, err = mc.Relabels(logger, rcs, m.Labels)
-			if err != nil {
+			m := c.newMetricGenerator(logger, mc, dp)
+			m.Labels, err = mc.Relabels(logger, metricRcs, m.Labels)
+			if err != nil || m.Labels == nil {
 				continue
 			}
 			metrics <- m
+			samples++
 		}
 	}
+	return datapoints, samples
+}
+
+// getDatapoints matches the datapoints of the metric in the data.
+func (c *CollectConfig) getDatapoints(logger log.Logger, mc *MetricConfig, data []byte) (dps []Datapoint) {
+	metricLogger := log.With(logger, "metric", mc.Name)
+	level.Debug(metricLogger).Log("title", "Raw Data", "data_format", c.DataFormat, "data", string(wrapper.Limit[byte](data, 256, wrapper.PosCenter, []byte(" ... ")...)))
+	switch c.DataFormat.ToLower() {
+	case Regex:
+		dps = mc.GetDatapointsByRegex(metricLogger, data)
+	case Json:
+		dps = mc.GetDatapointsByJson(metricLogger, data)
+	case Xml:
+		dps = mc.GetDatapointsByXml(metricLogger, data)
+	case Yaml:
+		dps = mc.GetDatapointsByYaml(metricLogger, data)
+	case Csv, Tsv:
+		dps = mc.GetDatapointsByCsv(metricLogger, data, c.CSV)
+	case Prometheus:
+		dps = mc.GetDatapointsByPrometheus(metricLogger, data)
+	}
+	return dps
+}
+
+// newMetricGenerator returns the generator of the datapoint, with the labels before relabeling.
+func (c *CollectConfig) newMetricGenerator(logger log.Logger, mc *MetricConfig, dp Datapoint) MetricGenerator {
+	m := MetricGenerator{
+		logger:     logger,
+		MetricType: mc.MetricType,
+		Name:       mc.Name,
+		Labels:     Labels{Label{Name: "name", Value: mc.Name}},
+		Datapoint:  mc,
+	}
+	if c.DataFormat == Prometheus {
+		// keep the original label set of the series
+		m.Labels = Labels{}
+	}
+	for name, val := range dp {
+		m.Labels.Append(name, val)
+	}
+	return m
 }
 
 func (c *CollectConfig) SetLogger(logger log.Logger) {
@@ -213,62 +373,106 @@ func (c *CollectConfig) StopStreamCollect() {
 	for i := range c.Datasource {
 		c.Datasource[i].Close()
 	}
+	if !c.metricsKept {
+		streamSeries.DeleteLabelValues(c.Name)
+	}
 }
 
-func (c *CollectConfig) tailDsStream(ctx context.Context, ds *Datasource, stream buffer.ReadLineCloser, metrics chan<- MetricGenerator) {
+// tailDsStream handles the lines of the stream until it is closed or the context is done, it returns the number of
+// lines read.
+func (c *CollectConfig) tailDsStream(ctx context.Context, ds *Datasource, stream buffer.ReadLineCloser, metrics chan<- MetricGenerator) (lines int) {
 	defer stream.Close()
+	// close the stream when the context is done, so that the blocked ReadLine returns.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-stop:
+		}
+	}()
 	var line []byte
 	var err error
+	var read int64
 	logger := log.With(c.logger, "datasource", ds.Name)
-	rcs := append(c.RelabelConfigs, ds.RelabelConfigs...)
+	rcs := c.relabelConfigs(ds)
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return lines
 		default:
 			line, err = stream.ReadLine()
 			if err != nil {
 				level.Warn(c.logger).Log("log", "failed to read line", "err", err)
-				return
+				return lines
 			}
-			c.GetMetric(logger, line, rcs, metrics)
+			lines++
+			datapoints, samples := c.GetMetric(logger, line, rcs, metrics)
+			observeData(c.Name, ds.Name, len(line), 1, datapoints, samples)
+			datasourceLastSuccess.WithLabelValues(c.Name, ds.Name).Set(float64(time.Now().UnixNano()) / 1e9)
+			read += int64(len(line))
+			// the series is looked up for each line, it is deleted when the stream of the previous configuration stops
+			datasourceStreamOffset.WithLabelValues(c.Name, ds.Name, ds.Url).Set(float64(streamOffset(stream, read)))
 		}
 	}
 }
 
+// handleData updates the series of the stream collect with the metrics of the data, ttl overrides the ttl of the
+// metrics if it is greater than 0. The first error of the metrics that fail to be updated is returned.
+func (c *CollectConfig) handleData(logger log.Logger, data []byte, rcs RelabelConfigs, ttl time.Duration) (datapoints, samples int, err error) {
+	metrics := make(chan MetricGenerator, 10)
+	go func() {
+		defer close(metrics)
+		datapoints, samples = c.GetMetric(logger, data, rcs, metrics)
+	}()
+	failed := 0
+	for metric := range metrics {
+		if e := c.metrics.handleWithTTL(metric, ttl); e != nil {
+			if failed++; err == nil {
+				err = e
+			}
+		}
+	}
+	if failed > 1 {
+		err = fmt.Errorf("%s (and %d more errors)", err, failed-1)
+	}
+	return datapoints, samples, err
+}
+
 func (c *CollectConfig) StartStreamCollect(ctx context.Context) error {
 	metrics := make(chan MetricGenerator, 10)
 	for i := range c.Datasource {
 		if c.Datasource[i].ReadMode == Stream {
+			if _, ok, _ := c.Datasource[i].globFiles(); ok {
+				go c.tailFiles(ctx, c.Datasource[i], metrics)
+				continue
+			}
+			if isListenType(c.Datasource[i].Type) {
+				if err := c.listen(ctx, c.Datasource[i]); err != nil {
+					level.Error(c.logger).Log("log", "failed to start stream collect", "err", err, "datasource", c.Datasource[i].Name)
+					return err
+				}
+				continue
+			}
 			stream, err := c.Datasource[i].GetLineStream(ctx, log.With(c.logger, "datasource", c.Datasource[i].Name))
 			if err != nil {
 				level.Error(c.logger).Log("log", "failed to start stream collect", "err", err, "datasource", c.Datasource[i].Name)
 				return err
 			}
-			go func(ds *Datasource, buf buffer.ReadLineCloser) {
-				var e error
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						c.tailDsStream(ctx, ds, buf, metrics)
-					}
-
-					buf, e = ds.GetLineStream(ctx, log.With(c.logger, "datasource", ds.Name))
-					if e != nil {
-						level.Error(c.logger).Log("log", "failed to start stream collect, retry...", "err", err, "datasource", ds.Name)
-					}
-				}
-			}(c.Datasource[i], stream)
+			go c.tailStream(ctx, c.Datasource[i], stream, metrics)
 		}
 	}
 	go func() {
+		ticker := time.NewTicker(streamExpireInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				close(metrics)
 				return
+			case <-ticker.C:
+				c.metrics.expire()
 			case metric := <-metrics:
 				err := c.metrics.handle(metric)
 				if err != nil {
@@ -280,6 +484,107 @@ func (c *CollectConfig) StartStreamCollect(ctx context.Context) error {
 	return nil
 }
 
+// tailStream reads the stream of the datasource until the context is done, the stream is reopened if it is closed.
+// It is reopened immediately if it has read some lines, otherwise after a delay that doubles with each attempt. The
+// command of an exec datasource is always restarted after the delay, so that a command that exits is not run in a
+// loop. The reconnection time and the id of the last event of a Server-Sent Events stream are honored.
+func (c *CollectConfig) tailStream(ctx context.Context, ds *Datasource, buf buffer.ReadLineCloser, metrics chan<- MetricGenerator) {
+	var e error
+	var lastEventID string
+	defer datasourceStreamOffset.DeleteLabelValues(c.Name, ds.Name, ds.Url)
+	opened := buf != nil
+	retryInterval, delay := streamRetryInterval, streamRetryInterval
+	wait := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > streamMaxRetryInterval {
+			delay = streamMaxRetryInterval
+		}
+		return true
+	}
+	for {
+		if buf != nil {
+			datasourceSuccess.WithLabelValues(c.Name, ds.Name).Set(1)
+			lines := c.tailDsStream(ctx, ds, buf, metrics)
+			var retry time.Duration
+			if events, ok := buf.(*buffer.EventBuffer); ok {
+				lastEventID, retry = events.LastEventID(), events.Retry()
+				if retry > 0 {
+					retryInterval = retry
+				}
+			}
+			if lines > 0 && ds.Type != Exec {
+				delay = retryInterval
+			}
+			if (lines == 0 || retry > 0 || ds.Type == Exec) && !wait() {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if opened {
+			datasourceStreamReconnects.WithLabelValues(c.Name, ds.Name).Inc()
+		}
+		buf, e = ds.GetLineStream(context.WithValue(ctx, LastEventIDContextName, lastEventID), log.With(c.logger, "datasource", ds.Name))
+		opened = true
+		if e != nil {
+			datasourceSuccess.WithLabelValues(c.Name, ds.Name).Set(0)
+			level.Error(c.logger).Log("log", "failed to start stream collect, retry...", "err", e, "datasource", ds.Name, "delay", delay)
+			if !wait() {
+				return
+			}
+		}
+	}
+}
+
+// tailFiles tails every file matched by the glob pattern (or directory) of the datasource. The pattern is evaluated
+// again periodically, so that new files are tailed and the files that no longer exist are no longer tailed.
+func (c *CollectConfig) tailFiles(ctx context.Context, ds *Datasource, metrics chan<- MetricGenerator) {
+	tailers := map[string]context.CancelFunc{}
+	ticker := time.NewTicker(fileRescanInterval)
+	defer ticker.Stop()
+	for {
+		files, _, err := ds.globFiles()
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to match files", "err", err, "datasource", ds.Name)
+		}
+		matched := make(map[string]bool, len(files))
+		for _, file := range files {
+			matched[file] = true
+			if _, ok := tailers[file]; !ok {
+				level.Debug(c.logger).Log("msg", "start tailing file", "datasource", ds.Name, "file", file)
+				fileCtx, cancel := context.WithCancel(ctx)
+				tailers[file] = cancel
+				go c.tailStream(fileCtx, ds.withFile(file), nil, metrics)
+			}
+		}
+		for file, cancel := range tailers {
+			if !matched[file] {
+				level.Debug(c.logger).Log("msg", "stop tailing file", "datasource", ds.Name, "file", file)
+				cancel()
+				delete(tailers, file)
+			}
+		}
+		if p := getPositions(c.logger); p != nil && err == nil {
+			if pattern, ok := ds.globPattern(); ok {
+				p.Prune(ds.collect, pattern, matched)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 type CollectContext struct {
 	*CollectConfig
 	cancelFunc context.CancelFunc
@@ -293,6 +598,7 @@ func (c *CollectContext) Describe(_ chan<- *prometheus.Desc) {
 
 func (c *CollectContext) Collect(proMetrics chan<- prometheus.Metric) {
 	mgs := NewMetricGenerators(10, c.logger)
+	var cached []*Datasource
 	go func() {
 		wg := sync.WaitGroup{}
 		for i := range c.Datasource {
@@ -303,10 +609,20 @@ func (c *CollectContext) Collect(proMetrics chan<- prometheus.Metric) {
 				} else if ds.AllowReplace {
 					if len(c.DatasourceUrl) != 0 {
 						ds.Url = c.DatasourceUrl
+						// the cache only holds the metrics of the configured url
+						ds.cache = nil
 					}
 				}
 			}
-			if ds.ReadMode != Stream {
+			if ds.ReadMode != Stream && ds.AllowReplace && c.discovery != nil && len(c.DatasourceUrl) == 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					c.collectTargets(&ds, mgs.metrics)
+				}()
+			} else if ds.ReadMode != Stream && ds.cache != nil {
+				cached = append(cached, c.Datasource[i])
+			} else if ds.ReadMode != Stream {
 				wg.Add(1)
 				go func(idx int) {
 					defer wg.Done()
@@ -318,6 +634,9 @@ func (c *CollectContext) Collect(proMetrics chan<- prometheus.Metric) {
 		close(mgs.metrics)
 	}()
 	mgs.Collect(proMetrics)
+	for _, ds := range cached {
+		c.collectCache(ds, proMetrics)
+	}
 	c.metrics.Collect(proMetrics)
 }
 
@@ -340,29 +659,21 @@ func (mgs *MetricGenerators) Ch() chan MetricGenerator {
 	return mgs.metrics
 }
 func (mgs *MetricGenerators) Collect(proMetrics chan<- prometheus.Metric) {
+	merger := newSeriesMerger(true)
 	for metric := range mgs.metrics {
-		if metric.Labels.Has(LabelMetricValues) {
-			ms, errs := metric.GetMetrics()
-			for _, err := range errs {
-				if err != nil {
-					collectErrorCount.WithLabelValues("metric", metric.Name).Inc()
-					level.Error(log.With(mgs.logger, "metric", metric.Name)).Log("log", "failed to get prometheus metric", "err", err)
-				}
-			}
-			for _, m := range ms {
-				if m != nil {
-					proMetrics <- m
-				}
-			}
-		} else {
-			m, err := metric.getMetric()
-			if err != nil {
-				collectErrorCount.WithLabelValues("metric", metric.Name).Inc()
-				level.Error(log.With(mgs.logger, "metric", metric.Name)).Log("log", "failed to get prometheus metric", "err", err)
-			} else {
-				proMetrics <- m
+		ms, errs := metric.prometheusMetrics()
+		for _, m := range ms {
+			if err := merger.add(&metric, m); err != nil {
+				errs = append(errs, err)
 			}
 		}
+		for _, err := range errs {
+			collectErrorCount.WithLabelValues("metric", metric.Name).Inc()
+			level.Error(log.With(mgs.logger, "metric", metric.Name)).Log("log", "failed to get prometheus metric", "err", err)
+		}
+	}
+	for _, m := range merger.metrics() {
+		proMetrics <- m
 	}
 }
 
@@ -390,8 +701,49 @@ func (c *Collects) StartStreamCollect(ctx context.Context) error {
 	}
 	return nil
 }
+
+// Secrets returns the values of all datasources that were read from "*_file" options.
+func (c Collects) Secrets() []string {
+	var values []string
+	for idx := range c {
+		for _, ds := range c[idx].Datasource {
+			values = append(values, ds.Secrets()...)
+		}
+	}
+	return values
+}
+
+func (c *Collects) StartDiscovery(ctx context.Context) {
+	for idx := range *c {
+		(*c)[idx].StartDiscovery(ctx)
+	}
+}
+func (c *Collects) StartScheduledCollect(ctx context.Context) {
+	for idx := range *c {
+		(*c)[idx].StartScheduledCollect(ctx)
+	}
+}
+
 func (c *Collects) StopStreamCollect() {
 	for idx := range *c {
 		(*c)[idx].StopStreamCollect()
 	}
 }
+
+// DeleteRemovedMetrics deletes the series of the datasource metrics of the collects and datasources that are not in
+// next, so that the datasources removed by a reload are no longer exported.
+func (c Collects) DeleteRemovedMetrics(next Collects) {
+	kept := map[[2]string]bool{}
+	for idx := range next {
+		for _, ds := range next[idx].Datasource {
+			kept[[2]string{next[idx].Name, ds.Name}] = true
+		}
+	}
+	for idx := range c {
+		for _, ds := range c[idx].Datasource {
+			if !kept[[2]string{c[idx].Name, ds.Name}] {
+				deleteDatasourceMetrics(c[idx].Name, ds.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v3"
+	"io"
+	"path"
+	"strings"
+)
+
+type Compression string
+
+const (
+	CompressionAuto  Compression = "auto"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+	CompressionNone  Compression = "none"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+func (c *Compression) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	switch comp := Compression(strings.ToLower(s)); comp {
+	case "":
+		*c = CompressionNone
+	case CompressionAuto, CompressionGzip, CompressionZstd, CompressionBzip2, CompressionNone:
+		*c = comp
+	default:
+		return fmt.Errorf("unknown compression: %s", s)
+	}
+	return nil
+}
+
+// detectCompression detects the compression by the extension of the file name, or by the magic bytes of the data.
+func detectCompression(name string, r *bufio.Reader) Compression {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	case ".bz2":
+		return CompressionBzip2
+	}
+	head, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(head, bzip2Magic):
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+type decompressReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *decompressReader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// decompress wraps the stream returned by GetStream with the decompressor of the configured compression.
+func (d *Datasource) decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	compression := d.Compression
+	if len(compression) == 0 || compression == CompressionNone {
+		return rc, nil
+	}
+	br := bufio.NewReader(rc)
+	if compression == CompressionAuto {
+		compression = detectCompression(d.Url, br)
+	}
+	r := &decompressReader{closers: []io.Closer{rc}}
+	switch compression {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("failed to decompress gzip data: %s", err)
+		}
+		r.Reader, r.closers = gr, append([]io.Closer{gr}, r.closers...)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("failed to decompress zstd data: %s", err)
+		}
+		r.Reader, r.closers = zr, append([]io.Closer{zr.IOReadCloser()}, r.closers...)
+	case CompressionBzip2:
+		r.Reader = bzip2.NewReader(br)
+	default:
+		r.Reader = br
+	}
+	return r, nil
+}
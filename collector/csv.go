@@ -0,0 +1,235 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/MicroOps-cn/data_exporter/pkg/wrapper"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type CSVConfig struct {
+	// Delimiter is the field separator, defaults to "," (or "\t" for tsv).
+	Delimiter string `yaml:"delimiter,omitempty" json:"delimiter"`
+	// Quote is the character used to quote fields, defaults to '"'.
+	Quote string `yaml:"quote,omitempty" json:"quote"`
+	// Header indicates that the first row (after skip_rows) contains the column names.
+	Header bool `yaml:"header,omitempty" json:"header"`
+	// Columns are the explicit column names, they take precedence over the header row.
+	Columns []string `yaml:"columns,omitempty" json:"columns"`
+	// Comment is the prefix of the rows that should be ignored.
+	Comment string `yaml:"comment,omitempty" json:"comment"`
+	// SkipRows is the number of rows to skip at the beginning of the data.
+	SkipRows int `yaml:"skip_rows,omitempty" json:"skip_rows"`
+}
+
+func (c *CSVConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain CSVConfig
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Verify()
+}
+
+func (c *CSVConfig) Verify() error {
+	if utf8.RuneCountInString(c.Delimiter) > 1 {
+		return fmt.Errorf("csv delimiter must be a single character: %q", c.Delimiter)
+	}
+	if utf8.RuneCountInString(c.Quote) > 1 {
+		return fmt.Errorf("csv quote must be a single character: %q", c.Quote)
+	}
+	if len(c.Delimiter) > 0 && c.Delimiter == c.Quote {
+		return fmt.Errorf("csv delimiter and quote must be different: %q", c.Delimiter)
+	}
+	if c.SkipRows < 0 {
+		return fmt.Errorf("csv skip_rows cannot be negative: %d", c.SkipRows)
+	}
+	return nil
+}
+
+func (c *CSVConfig) runes() (delimiter, quote rune) {
+	delimiter, quote = ',', '"'
+	if len(c.Delimiter) > 0 {
+		delimiter, _ = utf8.DecodeRuneInString(c.Delimiter)
+	}
+	if len(c.Quote) > 0 {
+		quote, _ = utf8.DecodeRuneInString(c.Quote)
+	}
+	return delimiter, quote
+}
+
+func (c *CSVConfig) isHeader(record []string) bool {
+	if len(c.Columns) == 0 || len(record) != len(c.Columns) {
+		return false
+	}
+	for i := range record {
+		if strings.TrimSpace(record[i]) != c.Columns[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse converts the data into datapoints, one per row, keyed by column name.
+// Columns without a name are keyed by their index. Rows identical to the configured
+// columns are treated as repeated headers and ignored.
+func (c *CSVConfig) Parse(data []byte) ([]Datapoint, error) {
+	delimiter, quote := c.runes()
+	reader := &csvReader{data: data, delimiter: delimiter, quote: quote, comment: []byte(c.Comment)}
+	columns := c.Columns
+	headerRead := !c.Header
+	var results []Datapoint
+	for row := 0; ; {
+		record, raw, err := reader.Read()
+		if err == io.EOF {
+			return results, nil
+		} else if err != nil {
+			return results, err
+		}
+		if len(record) == 1 && len(strings.TrimSpace(record[0])) == 0 {
+			continue
+		}
+		row++
+		if row <= c.SkipRows {
+			continue
+		}
+		if !headerRead {
+			headerRead = true
+			if len(c.Columns) == 0 {
+				columns = make([]string, len(record))
+				for i, name := range record {
+					columns[i] = strings.TrimSpace(name)
+				}
+			}
+			continue
+		}
+		if c.isHeader(record) {
+			continue
+		}
+		dp := Datapoint{"__line__": raw}
+		for i, val := range record {
+			if i < len(columns) && len(columns[i]) > 0 {
+				dp[columns[i]] = val
+			} else {
+				dp[strconv.Itoa(i)] = val
+			}
+		}
+		results = append(results, dp)
+	}
+}
+
+type csvReader struct {
+	data      []byte
+	pos       int
+	line      int
+	delimiter rune
+	quote     rune
+	comment   []byte
+}
+
+// Read returns the next record and its raw text. Quoted fields may contain delimiters,
+// newlines and doubled quotes.
+func (r *csvReader) Read() (record []string, raw string, err error) {
+	for len(r.comment) > 0 && bytes.HasPrefix(r.data[r.pos:], r.comment) {
+		if i := bytes.IndexByte(r.data[r.pos:], '\n'); i >= 0 {
+			r.pos += i + 1
+			r.line++
+		} else {
+			r.pos = len(r.data)
+		}
+	}
+	if r.pos >= len(r.data) {
+		return nil, "", io.EOF
+	}
+	r.line++
+	start, startLine := r.pos, r.line
+	var field strings.Builder
+	inQuote, fieldStart := false, true
+	for r.pos < len(r.data) {
+		ch, size := utf8.DecodeRune(r.data[r.pos:])
+		r.pos += size
+		switch {
+		case inQuote:
+			if ch == r.quote {
+				if next, nextSize := utf8.DecodeRune(r.data[r.pos:]); next == r.quote && nextSize > 0 {
+					field.WriteRune(r.quote)
+					r.pos += nextSize
+				} else {
+					inQuote = false
+				}
+			} else {
+				if ch == '\n' {
+					r.line++
+				}
+				field.WriteRune(ch)
+			}
+		case ch == r.quote && fieldStart:
+			inQuote, fieldStart = true, false
+		case ch == r.delimiter:
+			record = append(record, field.String())
+			field.Reset()
+			fieldStart = true
+		case ch == '\n':
+			record = append(record, strings.TrimSuffix(field.String(), "\r"))
+			return record, strings.TrimRight(string(r.data[start:r.pos]), "\r\n"), nil
+		default:
+			field.WriteRune(ch)
+			fieldStart = false
+		}
+	}
+	if inQuote {
+		return nil, "", fmt.Errorf("line %d: unterminated quoted field", startLine)
+	}
+	record = append(record, strings.TrimSuffix(field.String(), "\r"))
+	return record, strings.TrimRight(string(r.data[start:]), "\r\n"), nil
+}
+
+func (mc *MetricConfig) GetDatapointsByCsv(logger log.Logger, data []byte, csvConfig *CSVConfig) []Datapoint {
+	if csvConfig == nil {
+		csvConfig = &CSVConfig{}
+	}
+	dps, err := csvConfig.Parse(data)
+	if err != nil {
+		collectErrorCount.WithLabelValues("metric", mc.Name).Inc()
+		level.Error(logger).Log("msg", "failed to parse csv data.", "err", err, "data", string(wrapper.Limit[byte](data, 256, wrapper.PosCenter, []byte(" ... ")...)))
+	}
+	level.Debug(logger).Log("title", "Datapoint Match by CSV", "data", string(wrapper.Limit[byte](data, 256, wrapper.PosCenter, []byte(" ... ")...)), "resultCount", len(dps))
+	for _, dp := range dps {
+		labels := make(map[string]string, len(mc.Match.Labels))
+		for name, column := range mc.Match.Labels {
+			if val, ok := dp[column]; ok {
+				level.Debug(logger).Log("title", "Label Match by CSV", "data", dp["__line__"], "exp", column, "result", val, "label", name)
+				labels[name] = val
+			}
+		}
+		// columns that are not valid label names are only available through match.labels
+		for name := range dp {
+			if !model.LabelName(name).IsValid() {
+				delete(dp, name)
+			}
+		}
+		for name, val := range labels {
+			dp[name] = val
+		}
+	}
+	return dps
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/hpcloud/tail"
 	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 	"gopkg.in/yaml.v3"
@@ -42,6 +43,17 @@ const (
 	File  DatasourceType = "file"
 	Tcp   DatasourceType = "tcp"
 	Udp   DatasourceType = "udp"
+	// Unix and Unixgram read a unix domain socket, of type SOCK_STREAM and SOCK_DGRAM.
+	Unix     DatasourceType = "unix"
+	Unixgram DatasourceType = "unixgram"
+	Exec     DatasourceType = "exec"
+	// ListenUDP, ListenTCP and ListenHTTP bind the address of the url and receive the data pushed by the senders.
+	ListenUDP  DatasourceType = "listen_udp"
+	ListenTCP  DatasourceType = "listen_tcp"
+	ListenHTTP DatasourceType = "listen_http"
+	// Ws and Wss read the messages of a websocket connection.
+	Ws  DatasourceType = "ws"
+	Wss DatasourceType = "wss"
 )
 
 func (d DatasourceType) ToLower() DatasourceType {
@@ -65,13 +77,39 @@ func (d DatasourceReadMode) ToLower() DatasourceReadMode {
 	return DatasourceReadMode(strings.ToLower(string(d)))
 }
 
+// HTTPStreamFormat is the framing of the body of an HTTP datasource in stream mode.
+type HTTPStreamFormat string
+
+const (
+	// HTTPStreamLine splits the body into lines, e.g. NDJSON feeds or Kubernetes watch APIs.
+	HTTPStreamLine HTTPStreamFormat = "line"
+	// HTTPStreamSSE reads the body as Server-Sent Events, the data of each event is handled as a line.
+	HTTPStreamSSE HTTPStreamFormat = "sse"
+)
+
 type HTTPConfig struct {
 	HTTPClientConfig promconfig.HTTPClientConfig `yaml:"http_client_config,inline"`
 	Body             string                      `yaml:"body,omitempty"`
-	Headers          map[string]string           `yaml:"headers,omitempty"`
-	Method           string                      `yaml:"method,omitempty"`
-	ValidStatusCodes []int                       `yaml:"valid_status_codes,omitempty"`
-	MaxConnectTime   time.Duration               `yaml:"max_connect_time"`
+	// BodyFile is the file that contains the body, it is read when the configuration is loaded.
+	BodyFile string            `yaml:"body_file,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	// HeadersFile maps the header names to the files that contain their values.
+	HeadersFile      map[string]string `yaml:"headers_file,omitempty"`
+	Method           string            `yaml:"method,omitempty"`
+	ValidStatusCodes []int             `yaml:"valid_status_codes,omitempty"`
+	MaxConnectTime   time.Duration     `yaml:"max_connect_time"`
+	// StreamFormat is the framing of the body in stream and line mode, defaults to "line".
+	StreamFormat HTTPStreamFormat `yaml:"stream_format,omitempty"`
+	secretValues []string
+}
+
+// readSecretFile reads the file referenced by a "*_file" option.
+func readSecretFile(option, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", option, err)
+	}
+	return string(data), nil
 }
 
 func (h *HTTPConfig) UnmarshalYAML(value *yaml.Node) error {
@@ -86,24 +124,99 @@ func (h *HTTPConfig) UnmarshalYAML(value *yaml.Node) error {
 	if h.MaxConnectTime < time.Millisecond {
 		return fmt.Errorf("timeout value cannot be less than 1 ms: timeout=%s", h.MaxConnectTime)
 	}
+	switch h.StreamFormat {
+	case "", HTTPStreamLine, HTTPStreamSSE:
+	default:
+		return fmt.Errorf("unknown stream_format: %s", h.StreamFormat)
+	}
+	if len(h.BodyFile) > 0 {
+		if len(h.Body) > 0 {
+			return fmt.Errorf("at most one of body and body_file must be configured")
+		}
+		if h.Body, err = readSecretFile("body_file", h.BodyFile); err != nil {
+			return err
+		}
+		h.secretValues = append(h.secretValues, h.Body)
+	}
+	for name, file := range h.HeadersFile {
+		if _, ok := h.Headers[name]; ok {
+			return fmt.Errorf("header %q is configured in both headers and headers_file", name)
+		}
+		value, err := readSecretFile("headers_file", file)
+		if err != nil {
+			return err
+		}
+		if h.Headers == nil {
+			h.Headers = make(map[string]string, len(h.HeadersFile))
+		}
+		h.Headers[name] = strings.TrimSpace(value)
+		h.secretValues = append(h.secretValues, h.Headers[name])
+	}
 	return nil
 }
 
-func (h HTTPConfig) GetStream(ctx context.Context, name, targetURL string) (io.ReadCloser, error) {
+func (h HTTPConfig) secrets() []string {
+	return h.secretValues
+}
+
+// splitUnixURL splits the url of an HTTP request over a unix socket, e.g. "unix:///var/run/docker.sock:/containers/json",
+// into the path of the socket and the url of the request.
+func splitUnixURL(targetURL string) (socket, requestURL string, ok bool) {
+	if !strings.HasPrefix(targetURL, "unix://") {
+		return "", targetURL, false
+	}
+	socket, path := strings.TrimPrefix(targetURL, "unix://"), "/"
+	if idx := strings.Index(socket, ":"); idx >= 0 {
+		socket, path = socket[:idx], socket[idx+1:]
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return socket, "http://localhost" + path, true
+}
+
+// newClient returns the client of the config and the url of the request, which is sent over the unix socket of a
+// "unix://" url.
+func (h HTTPConfig) newClient(name, targetURL string, opts ...promconfig.HTTPClientOption) (*http.Client, string, error) {
+	socket, targetURL, overUnix := splitUnixURL(targetURL)
 	dialerFunc := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if overUnix {
+			network, addr = "unix", socket
+		}
 		conn, err := net.DialTimeout(network, addr, h.MaxConnectTime)
 		if err != nil {
 			return nil, err
 		}
 		return conn, nil
 	}
-	client, err := promconfig.NewClientFromConfig(h.HTTPClientConfig, name, promconfig.WithKeepAlivesDisabled(), promconfig.WithDialContextFunc(dialerFunc))
+	opts = append(opts, promconfig.WithKeepAlivesDisabled(), promconfig.WithDialContextFunc(dialerFunc))
+	client, err := promconfig.NewClientFromConfig(h.HTTPClientConfig, name, opts...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if !strings.HasPrefix(targetURL, "http://") && !strings.HasPrefix(targetURL, "https://") {
 		targetURL = "http://" + targetURL
 	}
+	return client, targetURL, nil
+}
+
+// setHeaders sets the custom headers of the config to the request.
+func (h HTTPConfig) setHeaders(request *http.Request) {
+	for key, value := range h.Headers {
+		if cases.Title(language.English).String(key) == "Host" {
+			request.Host = value
+			continue
+		}
+
+		request.Header.Set(key, value)
+	}
+}
+
+func (h HTTPConfig) GetStream(ctx context.Context, name, targetURL string) (io.ReadCloser, error) {
+	client, targetURL, err := h.newClient(name, targetURL)
+	if err != nil {
+		return nil, err
+	}
 	var body io.Reader
 	if h.Body != "" {
 		body = strings.NewReader(h.Body)
@@ -112,13 +225,15 @@ func (h HTTPConfig) GetStream(ctx context.Context, name, targetURL string) (io.R
 	if err != nil {
 		return nil, err
 	}
-	for key, value := range h.Headers {
-		if cases.Title(language.English).String(key) == "Host" {
-			request.Host = value
-			continue
+	h.setHeaders(request)
+	if h.StreamFormat == HTTPStreamSSE {
+		if len(request.Header.Get("Accept")) == 0 {
+			request.Header.Set("Accept", "text/event-stream")
+		}
+		request.Header.Set("Cache-Control", "no-cache")
+		if id, ok := ctx.Value(LastEventIDContextName).(string); ok && len(id) > 0 {
+			request.Header.Set("Last-Event-ID", id)
 		}
-
-		request.Header.Set(key, value)
 	}
 	resp, err := client.Do(request.WithContext(ctx))
 	if err != nil {
@@ -160,6 +275,21 @@ type Datasource struct {
 	ReadMode             DatasourceReadMode `yaml:"read_mode"`
 	Config               Streamer           `yaml:"-"`
 	Whence               int                `yaml:"whence"`
+	// PathLabel is the label that holds the path of the file read by a file datasource whose url is a glob pattern
+	// or a directory.
+	PathLabel string `yaml:"path_label,omitempty"`
+	// Compression of the data, the data is decompressed before max_content_length is applied.
+	Compression Compression `yaml:"compression,omitempty"`
+	// Interval is the interval at which the datasource is collected in the background, defaults to the interval of the collect.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	cache    *metricCache
+	// name of the collect to which the datasource belongs
+	collect string
+	// the url has been expanded from a glob pattern
+	expanded bool
+	// implicitRelabelConfigs are added by the exporter (e.g. the path label of the files matched by a glob pattern),
+	// they run before the relabel_configs of the collect.
+	implicitRelabelConfigs RelabelConfigs
 
 	// Deprecated
 	HTTPConfig *HTTPConfig `yaml:"http,omitempty"`
@@ -204,6 +334,9 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 		return err
 	} else {
 		d.ReadMode = d.ReadMode.ToLower()
+		if len(d.ReadMode) == 0 && isListenType(d.Type) {
+			d.ReadMode = Stream
+		}
 		switch d.ReadMode {
 		case "", FullText:
 			d.ReadMode = Full
@@ -220,8 +353,14 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 				}
 			}
 		}
+		d.Type = d.Type.ToLower()
 		switch d.Type {
 		case File:
+			if len(d.PathLabel) == 0 {
+				d.PathLabel = DefaultFilePathLabel
+			} else if !model.LabelName(d.PathLabel).IsValid() {
+				return fmt.Errorf("%q is invalid path_label", d.PathLabel)
+			}
 		case Http, Https:
 			d.Type = Http
 			if d.HTTPConfig != nil {
@@ -231,13 +370,13 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 				if err = value.Decode(&struct {
 					Config *HTTPConfig
 				}{Config: httpConfig}); err != nil {
-					return nil
+					return err
 				}
 				d.Config = httpConfig
 			} else {
 				d.Config = &DefaultHttpConfig
 			}
-		case Tcp, Udp:
+		case Tcp, Udp, Unix, Unixgram:
 			if d.Type == Tcp && d.TCPConfig != nil {
 				d.Config = d.TCPConfig
 			} else if d.Type == Udp && d.UDPConfig != nil {
@@ -248,7 +387,7 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 					if err = value.Decode(&struct {
 						Config *NetConfig
 					}{Config: netConfig}); err != nil {
-						return nil
+						return err
 					}
 				}
 				d.Config = netConfig
@@ -264,6 +403,53 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 					*d.Config.(*NetConfig).MaxTransferTime = time.Second * 3
 				}
 			}
+		case Exec:
+			if !execEnabled {
+				return fmt.Errorf("exec datasource is disabled, it can be enabled with --collector.exec.enable")
+			}
+			if d.AllowReplace {
+				return fmt.Errorf("allow_replace is not supported by %s datasource", d.Type)
+			}
+			execConfig := new(ExecConfig)
+			if obj.Config != nil {
+				if err = value.Decode(&struct {
+					Config *ExecConfig
+				}{Config: execConfig}); err != nil {
+					return err
+				}
+			}
+			d.Config = execConfig
+		case Ws, Wss:
+			if d.ReadMode == Full && len(d.EndOf) == 0 {
+				// the messages are read until end_of, the server may never close the connection
+				return fmt.Errorf("end_of is required by %s datasource in full read_mode", d.Type)
+			}
+			wsConfig := NewWebSocketConfig()
+			if obj.Config != nil {
+				if err = value.Decode(&struct {
+					Config *WebSocketConfig
+				}{Config: wsConfig}); err != nil {
+					return err
+				}
+			}
+			d.Config = wsConfig
+		case ListenUDP, ListenTCP, ListenHTTP:
+			if d.ReadMode != Stream {
+				return fmt.Errorf("%s datasource only supports stream read_mode", d.Type)
+			} else if d.AllowReplace {
+				return fmt.Errorf("allow_replace is not supported by %s datasource", d.Type)
+			}
+			listenConfig := new(ListenConfig)
+			if obj.Config != nil {
+				if err = value.Decode(&struct {
+					Config *ListenConfig
+				}{Config: listenConfig}); err != nil {
+					return err
+				}
+			} else if err = listenConfig.Verify(); err != nil {
+				return err
+			}
+			d.Config = listenConfig
 		default:
 			return fmt.Errorf("Unknown datasource type: %s. ", d.Type)
 		}
@@ -290,6 +476,15 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 		if len(d.LineSeparator) == 0 {
 			d.LineSeparator = []string{"\n"}
 		}
+		if d.Interval < 0 {
+			return fmt.Errorf("interval cannot be negative: %s", d.Interval)
+		}
+		if len(d.Compression) == 0 {
+			d.Compression = CompressionNone
+		} else if d.Type == File && d.ReadMode == Stream && d.Compression != CompressionNone {
+			// the tailed files are read as is
+			return fmt.Errorf("compression %s is not supported by file datasource in stream mode", d.Compression)
+		}
 	}
 	d.HTTPConfig = nil
 	d.TCPConfig = nil
@@ -297,12 +492,23 @@ func (d *Datasource) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+// Secrets returns the values of the datasource that were read from "*_file" options.
+func (d *Datasource) Secrets() []string {
+	if s, ok := d.Config.(interface{ secrets() []string }); ok {
+		return s.secrets()
+	}
+	return nil
+}
+
 func (d *Datasource) ReadAll(ctx context.Context) ([]byte, error) {
 	var reader io.Reader
 	rc, err := d.GetStream(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if rc, err = d.decompress(rc); err != nil {
+		return nil, err
+	}
 	defer rc.Close()
 	reader = io.LimitReader(rc, *d.MaxContentLength)
 	if d.MinContentLength != nil && *d.MinContentLength > 0 {
@@ -331,7 +537,7 @@ func (d *Datasource) GetLineStream(ctx context.Context, logger log.Logger) (buff
 
 	if d.Type.ToLower() == File && d.ReadMode.ToLower() == Stream {
 		if t, err := tail.TailFile(d.Url, tail.Config{
-			Location:    &tail.SeekInfo{Whence: d.Whence},
+			Location:    resumeLocation(logger, d.collect, d.Url, d.Whence),
 			Follow:      true,
 			ReOpen:      true,
 			LineSep:     d.LineSeparator,
@@ -339,6 +545,8 @@ func (d *Datasource) GetLineStream(ctx context.Context, logger log.Logger) (buff
 			Logger:      stdlog.New(log.NewStdlibAdapter(logger), "", 0),
 		}); err != nil {
 			return nil, fmt.Errorf("Failed to open file %s: %s. ", d.Url, err)
+		} else if getPositions(logger) != nil {
+			return &positionTracker{Tail: t, collect: d.collect}, nil
 		} else {
 			return t, nil
 		}
@@ -347,18 +555,48 @@ func (d *Datasource) GetLineStream(ctx context.Context, logger log.Logger) (buff
 	if err != nil {
 		return nil, err
 	}
+	if rc, err = d.decompress(rc); err != nil {
+		return nil, err
+	}
+	if h, ok := d.Config.(*HTTPConfig); ok && h.StreamFormat == HTTPStreamSSE {
+		return buffer.NewEventBuffer(rc, *d.MaxContentLength, *d.LineMaxContentLength), nil
+	} else if messages, ok := rc.(buffer.ReadLineCloser); ok {
+		// each message of the stream is a line, e.g. the messages of a websocket
+		return messages, nil
+	}
 
 	return buffer.NewLineBuffer(rc, *d.MaxContentLength, *d.LineMaxContentLength, d.LineSeparator, []byte(d.EndOf)), nil
 }
 
 func (d *Datasource) GetStream(ctx context.Context) (io.ReadCloser, error) {
 	switch d.Type.ToLower() {
-	case Http, Tcp, Udp:
+	case Http, Tcp, Udp, Unix, Unixgram:
 		if body, err := d.Config.GetStream(ctx, d.Name, d.Url); err != nil {
 			return nil, fmt.Errorf("Request URL %s failed: %s. ", d.Url, err)
 		} else {
 			return body, nil
 		}
+	case Ws, Wss:
+		// a message is a line in stream mode, the whole content in full mode
+		maxMessageSize := 0
+		if d.ReadMode == Full && d.MaxContentLength != nil {
+			maxMessageSize = int(*d.MaxContentLength)
+		} else if d.ReadMode != Full && d.LineMaxContentLength != nil {
+			maxMessageSize = *d.LineMaxContentLength
+		}
+		if conn, err := d.Config.(*WebSocketConfig).dial(ctx, d.collect, d.Name, d.Url, d.EndOf, maxMessageSize); err != nil {
+			return nil, fmt.Errorf("Request URL %s failed: %s. ", d.Url, err)
+		} else {
+			return conn, nil
+		}
+	case ListenUDP, ListenTCP, ListenHTTP:
+		return d.Config.GetStream(ctx, d.Name, d.Url)
+	case Exec:
+		if stdout, err := d.Config.GetStream(ctx, d.Name, d.Url); err != nil {
+			return nil, fmt.Errorf("Failed to execute command %s: %s. ", d.Url, err)
+		} else {
+			return stdout, nil
+		}
 	case File:
 		if file, err := os.Open(d.Url); err != nil {
 			return nil, fmt.Errorf("Failed to open file %s: %s. ", d.Url, err)
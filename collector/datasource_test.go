@@ -14,10 +14,28 @@
 package collector
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/MicroOps-cn/data_exporter/testings"
+	"github.com/go-kit/log"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestReadLineClose(t *testing.T) {
@@ -63,3 +81,806 @@ func Test_ReadAll_ContentLength(t *testing.T) {
 	_, err := ds.ReadAll(context.TODO())
 	tt.AssertEqual(err, ErrorDataTooSort)
 }
+
+func enableExec(t *testing.T) {
+	execEnabled = true
+	t.Cleanup(func() { execEnabled = false })
+}
+
+func TestExecDatasource(t *testing.T) {
+	tt := testings.NewTesting(t)
+	enableExec(t)
+	var ds Datasource
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+type: exec
+name: exec-test
+url: sh
+config:
+  args: ["-c", "echo line1; echo line2; echo oops >&2; exit $EXIT_CODE"]
+  env:
+    EXIT_CODE: "3"
+  valid_exit_codes: [3]
+`), &ds))
+	tt.AssertEqual(ds.Type, Exec)
+	all, err := ds.ReadAll(context.TODO())
+	tt.AssertNoError(err)
+	tt.AssertEqual("line1\nline2\n", string(all))
+	tt.AssertEqual(float64(3), testutil.ToFloat64(execExitCode.WithLabelValues("exec-test")))
+	tt.AssertEqual(float64(5), testutil.ToFloat64(execStderrBytes.WithLabelValues("exec-test")))
+
+	ds.Config.(*ExecConfig).ValidExitCodes = nil
+	_, err = ds.ReadAll(context.TODO())
+	tt.AssertNotEqual(nil, err)
+
+	ds.ReadMode = Line
+	stream, err := ds.GetLineStream(context.TODO(), nil)
+	tt.AssertNoError(err)
+	defer stream.Close()
+	line, err := stream.ReadLine()
+	tt.AssertNoError(err)
+	tt.AssertEqual("line1", string(line))
+}
+
+func TestExecDatasourceAllowReplace(t *testing.T) {
+	tt := testings.NewTesting(t)
+	enableExec(t)
+	var ds Datasource
+	err := yaml.Unmarshal([]byte(`{type: exec, url: sh, allow_replace: true}`), &ds)
+	tt.AssertNotEqual(nil, err)
+}
+
+func TestExecDatasourceDisabled(t *testing.T) {
+	tt := testings.NewTesting(t)
+	var ds Datasource
+	tt.AssertNotEqual(nil, yaml.Unmarshal([]byte(`{type: exec, url: sh}`), &ds))
+	tt.AssertNotEqual(nil, yaml.Unmarshal([]byte(`{type: EXEC, url: sh}`), &ds))
+}
+
+func TestExecDatasourceKill(t *testing.T) {
+	tt := testings.NewTesting(t)
+	enableExec(t)
+	var ds Datasource
+	tt.AssertNoError(yaml.Unmarshal([]byte(`{type: exec, name: exec-kill, url: sh, read_mode: line, config: {args: ["-c", "echo line1; exec sleep 10"]}}`), &ds))
+	stream, err := ds.GetLineStream(context.TODO(), nil)
+	tt.AssertNoError(err)
+	line, err := stream.ReadLine()
+	tt.AssertNoError(err)
+	tt.AssertEqual("line1", string(line))
+	go func() { _, _ = stream.ReadLine() }()
+	tt.AssertNoError(stream.Close())
+	tt.AssertEqual(float64(-1), testutil.ToFloat64(execExitCode.WithLabelValues("exec-kill")))
+}
+
+func TestExecStreamBackoff(t *testing.T) {
+	tt := testings.NewTesting(t)
+	enableExec(t)
+	defer func(interval, maxInterval time.Duration) {
+		streamRetryInterval, streamMaxRetryInterval = interval, maxInterval
+	}(streamRetryInterval, streamMaxRetryInterval)
+	streamRetryInterval, streamMaxRetryInterval = time.Millisecond*50, time.Millisecond*200
+	var c CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+name: exec-backoff
+data_format: regex
+datasource:
+  - {type: exec, name: echo, url: echo, read_mode: stream, config: {args: ["value 1"]}}
+metrics:
+  - name: value
+    match:
+      datapoint: "value (?P<value>.+)"
+      labels:
+        __value__: value
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	reconnects := testutil.ToFloat64(datasourceStreamReconnects.WithLabelValues("exec-backoff", "echo"))
+	ctx, cancel := context.WithCancel(context.Background())
+	tt.AssertNoError(c.StartStreamCollect(ctx))
+	time.Sleep(time.Millisecond * 500)
+	cancel()
+	// 50ms + 100ms + 200ms..., the command exits after each line
+	restarts := testutil.ToFloat64(datasourceStreamReconnects.WithLabelValues("exec-backoff", "echo")) - reconnects
+	tt.AssertEqual(true, restarts >= 1 && restarts <= 4, fmt.Sprintf("restarts: %v", restarts))
+}
+
+func TestFileStreamPositions(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "access.log")
+	tt.AssertNoError(os.WriteFile(dataPath, []byte("line1\nline2\nline3\n"), 0644))
+	resetPositions := func() {
+		positionsPath = filepath.Join(dir, "positions.yaml")
+		positionsOnce = sync.Once{}
+		positions = &Positions{entries: map[positionKey]Position{}}
+	}
+	readLines := func(n int) []string {
+		ds := Datasource{ReadMode: Stream, Url: dataPath, Type: File, Whence: io.SeekStart, LineSeparator: []string{"\n"}, collect: "test"}
+		stream, err := ds.GetLineStream(context.TODO(), log.NewNopLogger())
+		tt.AssertNoError(err)
+		defer stream.Close()
+		var lines []string
+		for i := 0; i < n; i++ {
+			line, err := stream.ReadLine()
+			tt.AssertNoError(err)
+			lines = append(lines, string(line))
+		}
+		return lines
+	}
+	resetPositions()
+	tt.AssertEqual([]string{"line1", "line2"}, readLines(2))
+	tt.AssertNoError(getPositions(log.NewNopLogger()).Sync())
+
+	// resume from the saved offset after a restart
+	resetPositions()
+	tt.AssertEqual([]string{"line3"}, readLines(1))
+	tt.AssertNoError(getPositions(log.NewNopLogger()).Sync())
+
+	// truncated file is read from the beginning
+	resetPositions()
+	tt.AssertNoError(os.WriteFile(dataPath, []byte("line4\n"), 0644))
+	tt.AssertEqual([]string{"line4"}, readLines(1))
+	tt.AssertNoError(getPositions(log.NewNopLogger()).Sync())
+
+	// rotated file is read from the beginning
+	resetPositions()
+	tt.AssertNoError(os.Rename(dataPath, dataPath+".1"))
+	tt.AssertNoError(os.WriteFile(dataPath, []byte("line5\nline6\nline7\n"), 0644))
+	tt.AssertEqual([]string{"line5"}, readLines(1))
+}
+
+func TestPositionsPrune(t *testing.T) {
+	tt := testings.NewTesting(t)
+	p := &Positions{entries: map[positionKey]Position{}}
+	for _, pos := range []Position{
+		{Collect: "logs", Path: "/var/log/app/a.log"},
+		{Collect: "logs", Path: "/var/log/app/b.log"},
+		{Collect: "logs", Path: "/var/log/other.log"},
+		{Collect: "other", Path: "/var/log/app/b.log"},
+	} {
+		p.Put(pos)
+	}
+	p.Prune("logs", "/var/log/app/*.log", map[string]bool{"/var/log/app/a.log": true})
+	_, ok := p.Get("logs", "/var/log/app/a.log")
+	tt.AssertEqual(true, ok)
+	_, ok = p.Get("logs", "/var/log/app/b.log")
+	tt.AssertEqual(false, ok)
+	_, ok = p.Get("logs", "/var/log/other.log")
+	tt.AssertEqual(true, ok)
+	_, ok = p.Get("other", "/var/log/app/b.log")
+	tt.AssertEqual(true, ok)
+}
+
+func TestFileDatasourceGlob(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "a.log"), []byte("a,1\n"), 0644))
+	// "$" is not expanded in the path label
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "b$1.log"), []byte("b,2\n"), 0644))
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c,3\n"), 0644))
+	var c CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+name: glob
+data_format: csv
+csv:
+  columns: [host, value]
+relabel_configs:
+  - source_labels: [path]
+    regex: ".*/([^/]+)"
+    target_label: file
+datasource:
+  - type: file
+    url: `+filepath.Join(dir, "*.log")+`
+    read_mode: line
+    whence: 0
+metrics:
+  - name: requests
+    match:
+      labels:
+        __value__: value
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	ds := c.Datasource[0]
+	files, ok, err := ds.globFiles()
+	tt.AssertNoError(err)
+	tt.AssertEqual(true, ok)
+	tt.AssertEqual([]string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b$1.log")}, files)
+
+	metrics := make(chan MetricGenerator, 10)
+	c.GetMetricByDs(context.TODO(), log.NewNopLogger(), ds, metrics)
+	close(metrics)
+	paths, names := map[string]string{}, map[string]string{}
+	for metric := range metrics {
+		paths[metric.Labels.Get("host")] = metric.Labels.Get(DefaultFilePathLabel)
+		names[metric.Labels.Get("host")] = metric.Labels.Get("file")
+	}
+	tt.AssertEqual(map[string]string{"a": filepath.Join(dir, "a.log"), "b": filepath.Join(dir, "b$1.log")}, paths)
+	// the path label is set before the relabel_configs of the collect
+	tt.AssertEqual(map[string]string{"a": "a.log", "b": "b$1.log"}, names)
+
+	// stream mode picks up new files
+	defer func(interval time.Duration) { fileRescanInterval = interval }(fileRescanInterval)
+	fileRescanInterval = time.Millisecond * 50
+	ds.ReadMode = Stream
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streamMetrics := make(chan MetricGenerator, 10)
+	go c.tailFiles(ctx, ds, streamMetrics)
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "d.log"), []byte("d,4\n"), 0644))
+	hosts := map[string]bool{}
+	timeout := time.After(time.Second * 5)
+	for len(hosts) < 3 {
+		select {
+		case metric := <-streamMetrics:
+			hosts[metric.Labels.Get("host")] = true
+		case <-timeout:
+			t.Fatalf("timeout waiting for metrics of new file: %v", hosts)
+		}
+	}
+	tt.AssertEqual(map[string]bool{"a": true, "b": true, "d": true}, hosts)
+}
+
+func TestDatasourceCompression(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	content := strings.Repeat("line\n", 100)
+	var gzBuf, zstdBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	_, err := gw.Write([]byte(content))
+	tt.AssertNoError(err)
+	tt.AssertNoError(gw.Close())
+	zw, err := zstd.NewWriter(&zstdBuf)
+	tt.AssertNoError(err)
+	_, err = zw.Write([]byte(content))
+	tt.AssertNoError(err)
+	tt.AssertNoError(zw.Close())
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "access.log.1.gz"), gzBuf.Bytes(), 0644))
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "access.log.2"), zstdBuf.Bytes(), 0644))
+
+	for _, tc := range []struct {
+		file        string
+		compression string
+	}{
+		{file: "access.log.1.gz", compression: "auto"},
+		{file: "access.log.1.gz", compression: "gzip"},
+		{file: "access.log.2", compression: "auto"},
+		{file: "access.log.2", compression: "zstd"},
+	} {
+		var ds Datasource
+		tt.AssertNoError(yaml.Unmarshal([]byte(fmt.Sprintf("{type: file, url: %q, compression: %s}", filepath.Join(dir, tc.file), tc.compression)), &ds))
+		all, err := ds.ReadAll(context.TODO())
+		tt.AssertNoError(err)
+		tt.AssertEqual(content, string(all))
+
+		// max_content_length applies to the decompressed data
+		maxContentLength := int64(10)
+		ds.MaxContentLength = &maxContentLength
+		all, err = ds.ReadAll(context.TODO())
+		tt.AssertNoError(err)
+		tt.AssertEqual(content[:10], string(all))
+
+		ds.ReadMode = Line
+		stream, err := ds.GetLineStream(context.TODO(), nil)
+		tt.AssertNoError(err)
+		line, err := stream.ReadLine()
+		tt.AssertNoError(err)
+		tt.AssertEqual("line", string(line))
+		tt.AssertNoError(stream.Close())
+	}
+
+	var ds Datasource
+	tt.AssertNoError(yaml.Unmarshal([]byte(fmt.Sprintf("{type: file, url: %q}", filepath.Join(dir, "access.log.1.gz"))), &ds))
+	all, err := ds.ReadAll(context.TODO())
+	tt.AssertNoError(err)
+	tt.AssertEqual(gzBuf.Bytes(), all)
+	tt.AssertNotEqual(nil, yaml.Unmarshal([]byte(`{type: file, url: a.log, compression: lz4}`), &ds))
+	for _, compression := range []string{"auto", "gzip", "zstd", "bzip2"} {
+		tt.AssertNotEqual(nil, yaml.Unmarshal([]byte(`{type: file, url: a.log, read_mode: stream, compression: `+compression+`}`), &ds))
+	}
+	tt.AssertNoError(yaml.Unmarshal([]byte(`{type: file, url: a.log, read_mode: stream, compression: none}`), &ds))
+}
+
+func TestDatasourceScrapeMetrics(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "data.csv")
+	tt.AssertNoError(os.WriteFile(dataPath, []byte("a,1\nb,2\nc,x\n"), 0644))
+	var c CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+name: scrape-metrics
+data_format: csv
+csv:
+  columns: [host, value]
+datasource:
+  - name: lines
+    type: file
+    url: `+dataPath+`
+    read_mode: line
+  - name: missing
+    type: file
+    url: `+filepath.Join(dir, "missing.csv")+`
+metrics:
+  - name: requests
+    match:
+      labels:
+        __value__: value
+    relabel_configs:
+      - source_labels: [host]
+        regex: c
+        action: drop
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	metrics := make(chan MetricGenerator, 10)
+	tt.AssertNoError(c.GetMetricByDs(context.TODO(), log.NewNopLogger(), c.Datasource[0], metrics))
+	tt.AssertNotEqual(nil, c.GetMetricByDs(context.TODO(), log.NewNopLogger(), c.Datasource[1], metrics))
+	close(metrics)
+
+	tt.AssertEqual(float64(1), testutil.ToFloat64(datasourceSuccess.WithLabelValues("scrape-metrics", "lines")))
+	tt.AssertEqual(float64(9), testutil.ToFloat64(datasourceBytesRead.WithLabelValues("scrape-metrics", "lines")))
+	tt.AssertEqual(float64(3), testutil.ToFloat64(datasourceLinesRead.WithLabelValues("scrape-metrics", "lines")))
+	tt.AssertEqual(float64(3), testutil.ToFloat64(datasourceDatapointsParsed.WithLabelValues("scrape-metrics", "lines")))
+	tt.AssertEqual(float64(2), testutil.ToFloat64(datasourceSamplesEmitted.WithLabelValues("scrape-metrics", "lines")))
+	tt.AssertEqual(true, testutil.ToFloat64(datasourceLastSuccess.WithLabelValues("scrape-metrics", "lines")) > 0)
+	tt.AssertEqual(float64(0), testutil.ToFloat64(datasourceSuccess.WithLabelValues("scrape-metrics", "missing")))
+
+	// the offset of a stream is the offset in the tailed file
+	ds := *c.Datasource[0]
+	ds.ReadMode = Stream
+	ds.Whence = io.SeekStart
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streamMetrics := make(chan MetricGenerator, 10)
+	go c.tailStream(ctx, &ds, nil, streamMetrics)
+	timeout := time.After(time.Second * 5)
+	for received := 0; received < 2; received++ {
+		select {
+		case <-streamMetrics:
+		case <-timeout:
+			t.Fatalf("timeout waiting for metrics of stream")
+		}
+	}
+	for testutil.ToFloat64(datasourceStreamOffset.WithLabelValues("scrape-metrics", "lines", dataPath)) != 12 {
+		select {
+		case <-timeout:
+			t.Fatalf("timeout waiting for the offset of stream")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	tt.AssertEqual(float64(1), testutil.ToFloat64(datasourceSuccess.WithLabelValues("scrape-metrics", "lines")))
+}
+
+func TestDeleteRemovedMetrics(t *testing.T) {
+	tt := testings.NewTesting(t)
+	var before, after Collects
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+- name: reload
+  data_format: json
+  datasource: [{name: kept, type: file, url: a.json}, {name: removed, type: file, url: b.json}]
+  metrics: [{name: value, match: {datapoint: data}}]
+- name: removed
+  data_format: json
+  datasource: [{name: kept, type: file, url: a.json}]
+  metrics: [{name: value, match: {datapoint: data}}]
+`), &before))
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+- name: reload
+  data_format: json
+  datasource: [{name: kept, type: file, url: a.json}]
+  metrics: [{name: value, match: {datapoint: data}}]
+`), &after))
+	for _, labels := range [][2]string{{"reload", "kept"}, {"reload", "removed"}, {"removed", "kept"}} {
+		observeScrape(labels[0], labels[1], time.Now(), nil)
+		observeData(labels[0], labels[1], 1, 1, 1, 1)
+	}
+	before.DeleteRemovedMetrics(after)
+	// DeleteLabelValues returns whether the series existed
+	tt.AssertEqual(true, datasourceSuccess.DeleteLabelValues("reload", "kept"))
+	tt.AssertEqual(true, datasourceBytesRead.DeleteLabelValues("reload", "kept"))
+	for _, labels := range [][2]string{{"reload", "removed"}, {"removed", "kept"}} {
+		tt.AssertEqual(false, datasourceSuccess.DeleteLabelValues(labels[0], labels[1]))
+		tt.AssertEqual(false, datasourceLastSuccess.DeleteLabelValues(labels[0], labels[1]))
+		tt.AssertEqual(false, datasourceScrapeDuration.DeleteLabelValues(labels[0], labels[1]))
+		tt.AssertEqual(false, datasourceBytesRead.DeleteLabelValues(labels[0], labels[1]))
+		tt.AssertEqual(false, datasourceSamplesEmitted.DeleteLabelValues(labels[0], labels[1]))
+	}
+}
+
+func TestHTTPOverUnixSocket(t *testing.T) {
+	tt := testings.NewTesting(t)
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	tt.AssertNoError(err)
+	server := http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[{"path":%q,"query":%q}]`, r.URL.Path, r.URL.RawQuery)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	var ds Datasource
+	tt.AssertNoError(yaml.Unmarshal([]byte("type: http\nurl: unix://"+socket+":/containers/json?all=1\n"), &ds))
+	data, err := ds.ReadAll(context.TODO())
+	tt.AssertNoError(err)
+	tt.AssertEqual(`[{"path":"/containers/json","query":"all=1"}]`, string(data))
+
+	socket, requestURL, ok := splitUnixURL("unix:///var/run/docker.sock")
+	tt.AssertEqual([]interface{}{"/var/run/docker.sock", "http://localhost/", true}, []interface{}{socket, requestURL, ok})
+}
+
+// collectStreamSeries returns the values of the gauges of the stream collect, by their labels.
+func collectStreamSeries(t *testing.T, c *CollectConfig) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	c.metrics.Collect(ch)
+	close(ch)
+	result := map[string]float64{}
+	for metric := range ch {
+		var m dto.Metric
+		testings.NewTesting(t).AssertNoError(metric.Write(&m))
+		var labels []string
+		for _, label := range m.GetLabel() {
+			labels = append(labels, label.GetName()+"="+label.GetValue())
+		}
+		result[strings.Join(labels, ",")] = m.GetGauge().GetValue()
+	}
+	return result
+}
+
+// waitStreamSeries waits for the series of the stream collect with the given labels, and returns its value.
+func waitStreamSeries(t *testing.T, c *CollectConfig, key string) float64 {
+	for i := 0; i < 100; i++ {
+		if value, ok := collectStreamSeries(t, c)[key]; ok {
+			return value
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatalf("series %s not found in %v", key, collectStreamSeries(t, c))
+	return 0
+}
+
+func TestListenDatasource(t *testing.T) {
+	tt := testings.NewTesting(t)
+	freeAddr := func(network string) string {
+		if network == "udp" {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			tt.AssertNoError(err)
+			defer conn.Close()
+			return conn.LocalAddr().String()
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		tt.AssertNoError(err)
+		defer ln.Close()
+		return ln.Addr().String()
+	}
+	udpAddr, tcpAddr, httpAddr := freeAddr("udp"), freeAddr("tcp"), freeAddr("tcp")
+	config := `
+name: listen
+data_format: csv
+csv:
+  columns: [host, value]
+datasource:
+  - name: syslog
+    type: listen_udp
+    url: ` + udpAddr + `
+  - name: lines
+    type: listen_tcp
+    url: ` + tcpAddr + `
+    config:
+      max_connections: 1
+      max_message_size: 16
+  - name: webhook
+    type: listen_http
+    url: ` + httpAddr + `
+    config:
+      path: /push
+      source_label: sender
+      max_message_size: 16
+# the source label is set before the relabel_configs of the collect
+relabel_configs:
+  - source_labels: [sender]
+    regex: "(.+)"
+    target_label: peer
+  - action: labeldrop
+    regex: sender
+metrics:
+  - name: pushed
+    match:
+      labels:
+        __value__: value
+        host: host
+`
+	var c CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(config), &c))
+	tt.AssertEqual(Stream, c.Datasource[0].ReadMode)
+	c.SetLogger(log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tt.AssertNoError(c.StartStreamCollect(ctx))
+
+	series := func(c *CollectConfig) map[string]float64 { return collectStreamSeries(t, c) }
+	waitSeries := func(c *CollectConfig, key string) float64 { return waitStreamSeries(t, c, key) }
+
+	udpConn, err := net.Dial("udp", udpAddr)
+	tt.AssertNoError(err)
+	defer udpConn.Close()
+	_, err = udpConn.Write([]byte("udp-a,1\nudp-b,2"))
+	tt.AssertNoError(err)
+	tt.AssertEqual(float64(2), waitSeries(&c, "host=udp-b,source_address=127.0.0.1,value=2"))
+
+	tcpConn, err := net.Dial("tcp", tcpAddr)
+	tt.AssertNoError(err)
+	defer tcpConn.Close()
+	_, err = tcpConn.Write([]byte("tcp-a,3\n"))
+	tt.AssertNoError(err)
+	tt.AssertEqual(float64(3), waitSeries(&c, "host=tcp-a,source_address=127.0.0.1,value=3"))
+	// the connection limit is reached, the second connection is closed
+	rejected, err := net.Dial("tcp", tcpAddr)
+	tt.AssertNoError(err)
+	defer rejected.Close()
+	tt.AssertNoError(rejected.SetReadDeadline(time.Now().Add(time.Second * 2)))
+	_, err = rejected.Read(make([]byte, 1))
+	tt.AssertNotEqual(nil, err)
+	tt.AssertEqual(false, errors.Is(err, os.ErrDeadlineExceeded))
+	// a line longer than max_message_size closes the connection
+	_, err = tcpConn.Write([]byte(strings.Repeat("x", 32) + "\n"))
+	tt.AssertNoError(err)
+	tt.AssertNoError(tcpConn.SetReadDeadline(time.Now().Add(time.Second * 2)))
+	_, err = tcpConn.Read(make([]byte, 1))
+	tt.AssertNotEqual(nil, err)
+	tt.AssertEqual(false, errors.Is(err, os.ErrDeadlineExceeded))
+
+	resp, err := http.Post("http://"+httpAddr+"/push", "text/plain", strings.NewReader("http-a,4\n"))
+	tt.AssertNoError(err)
+	resp.Body.Close()
+	tt.AssertEqual(http.StatusNoContent, resp.StatusCode)
+	tt.AssertEqual(float64(4), series(&c)["host=http-a,peer=127.0.0.1,value=4"])
+	resp, err = http.Post("http://"+httpAddr+"/push", "text/plain", strings.NewReader(strings.Repeat("x", 32)))
+	tt.AssertNoError(err)
+	resp.Body.Close()
+	tt.AssertEqual(http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp, err = http.Get("http://" + httpAddr + "/push")
+	tt.AssertNoError(err)
+	resp.Body.Close()
+	tt.AssertEqual(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// the address is taken over by the same datasource of a new config, but not by another datasource
+	var other CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte("name: other\ndatasource:\n  - type: listen_udp\n    url: "+udpAddr+"\n"), &other))
+	other.SetLogger(log.NewNopLogger())
+	tt.AssertNotEqual(nil, other.StartStreamCollect(ctx))
+	var reloaded CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(config), &reloaded))
+	reloaded.SetLogger(log.NewNopLogger())
+	reloadCtx, reloadCancel := context.WithCancel(context.Background())
+	defer reloadCancel()
+	tt.AssertNoError(reloaded.StartStreamCollect(reloadCtx))
+	cancel()
+	_, err = udpConn.Write([]byte("udp-c,5"))
+	tt.AssertNoError(err)
+	tt.AssertEqual(float64(5), waitSeries(&reloaded, "host=udp-c,source_address=127.0.0.1,value=5"))
+	reloadCancel()
+	time.Sleep(time.Millisecond * 100)
+	conn, err := net.ListenPacket("udp", udpAddr)
+	tt.AssertNoError(err)
+	conn.Close()
+}
+
+func TestHTTPEventStream(t *testing.T) {
+	tt := testings.NewTesting(t)
+	var mux sync.Mutex
+	var connections int
+	var lastEventIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		connections++
+		conn := connections
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		mux.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		if conn == 1 {
+			// the connection is closed after the first event
+			_, _ = fmt.Fprint(w, "retry: 10\nid: 1\ndata: {\"host\":\"a\",\"value\":1}\n\n")
+			return
+		}
+		_, _ = fmt.Fprintf(w, ": keepalive\nid: %d\ndata: {\"host\":\"b\",\ndata: \"value\":2}\n\n", conn)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	var c CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+name: events
+data_format: json
+datasource:
+  - name: events
+    type: http
+    url: `+ts.URL+`
+    read_mode: stream
+    config:
+      stream_format: sse
+metrics:
+  - name: event_value
+    match:
+      labels:
+        __value__: value
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	reconnects := testutil.ToFloat64(datasourceStreamReconnects.WithLabelValues("events", "events"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tt.AssertNoError(c.StartStreamCollect(ctx))
+	tt.AssertEqual(float64(1), waitStreamSeries(t, &c, "host=a,value=1"))
+	tt.AssertEqual(float64(2), waitStreamSeries(t, &c, "host=b,value=2"))
+	mux.Lock()
+	defer mux.Unlock()
+	tt.AssertEqual([]string{"", "1"}, lastEventIDs)
+	tt.AssertEqual(reconnects+1, testutil.ToFloat64(datasourceStreamReconnects.WithLabelValues("events", "events")))
+}
+
+// newWebSocketServer returns a server that accepts the websocket handshake and passes the connection to handler.
+func newWebSocketServer(t *testing.T, handler func(conn *wsConn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+		netConn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer netConn.Close()
+		_, _ = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		_ = rw.Flush()
+		handler(&wsConn{rwc: netConn, r: rw.Reader, done: make(chan struct{}), messages: prometheus.NewCounter(prometheus.CounterOpts{Name: "test"}), onClose: func() {}})
+	}))
+}
+
+// writeServerFrame writes an unmasked frame, as sent by a server.
+func writeServerFrame(conn *wsConn, fin bool, opcode byte, payload string) error {
+	header := []byte{opcode, byte(len(payload))}
+	if fin {
+		header[0] |= 0x80
+	}
+	_, err := conn.rwc.Write(append(header, payload...))
+	return err
+}
+
+func TestWebSocketStream(t *testing.T) {
+	tt := testings.NewTesting(t)
+	pong := make(chan string, 1)
+	ts := newWebSocketServer(t, func(conn *wsConn) {
+		if msg, err := conn.readMessage(); err != nil || string(msg) != `{"subscribe":"cpu"}` {
+			t.Errorf("unexpected subscription message: %q, %v", msg, err)
+			return
+		}
+		_ = writeServerFrame(conn, false, wsText, `{"host":"a",`)
+		_ = writeServerFrame(conn, true, wsContinuation, `"value":1}`)
+		_ = writeServerFrame(conn, true, wsPing, "hi")
+		if _, opcode, payload, err := conn.readFrame(); err == nil && opcode == wsPong {
+			pong <- string(payload)
+		}
+		_ = writeServerFrame(conn, true, wsText, `{"host":"b","value":2}`)
+		_, _ = conn.readMessage()
+	})
+	defer ts.Close()
+
+	var c CollectConfig
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+name: ws
+data_format: json
+datasource:
+  - name: telemetry
+    url: `+strings.Replace(ts.URL, "http://", "ws://", 1)+`
+    read_mode: stream
+    config:
+      headers:
+        Authorization: Bearer token
+      send:
+        - msg: '{"subscribe":"cpu"}'
+metrics:
+  - name: ws_value
+    match:
+      labels:
+        __value__: value
+`), &c))
+	tt.AssertEqual(Ws, c.Datasource[0].Type)
+	c.SetLogger(log.NewNopLogger())
+	attempts := testutil.ToFloat64(websocketConnectAttempts.WithLabelValues("ws", "telemetry", "success"))
+	messages := testutil.ToFloat64(websocketMessagesReceived.WithLabelValues("ws", "telemetry"))
+	ctx, cancel := context.WithCancel(context.Background())
+	tt.AssertNoError(c.StartStreamCollect(ctx))
+	tt.AssertEqual(float64(1), waitStreamSeries(t, &c, "host=a,value=1"))
+	tt.AssertEqual(float64(2), waitStreamSeries(t, &c, "host=b,value=2"))
+	tt.AssertEqual("hi", <-pong)
+	tt.AssertEqual(attempts+1, testutil.ToFloat64(websocketConnectAttempts.WithLabelValues("ws", "telemetry", "success")))
+	tt.AssertEqual(messages+2, testutil.ToFloat64(websocketMessagesReceived.WithLabelValues("ws", "telemetry")))
+	tt.AssertEqual(float64(1), testutil.ToFloat64(websocketConnections.WithLabelValues("ws", "telemetry")))
+	cancel()
+	for i := 0; i < 100 && testutil.ToFloat64(websocketConnections.WithLabelValues("ws", "telemetry")) != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	tt.AssertEqual(float64(0), testutil.ToFloat64(websocketConnections.WithLabelValues("ws", "telemetry")))
+}
+
+func TestWebSocketReadAll(t *testing.T) {
+	tt := testings.NewTesting(t)
+	ts := newWebSocketServer(t, func(conn *wsConn) {
+		_ = writeServerFrame(conn, true, wsText, "a 1")
+		_ = writeServerFrame(conn, true, wsBinary, "b 2")
+		_ = writeServerFrame(conn, true, wsText, "c 3\nEOF")
+		_, _ = conn.readMessage()
+	})
+	defer ts.Close()
+
+	var ds Datasource
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+url: `+strings.Replace(ts.URL, "http://", "ws://", 1)+`
+end_of: EOF
+`), &ds))
+	data, err := ds.ReadAll(context.Background())
+	tt.AssertNoError(err)
+	tt.AssertEqual("a 1\nb 2\nc 3\n\n", string(data))
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	tt := testings.NewTesting(t)
+	ts := newWebSocketServer(t, func(conn *wsConn) {
+		// the pings are never answered
+		_, _ = io.Copy(io.Discard, conn.r)
+	})
+	defer ts.Close()
+
+	var ds Datasource
+	tt.AssertNoError(yaml.Unmarshal([]byte(`
+name: keepalive
+url: `+strings.Replace(ts.URL, "http://", "ws://", 1)+`
+read_mode: stream
+config:
+  ping_interval: 20ms
+  pong_timeout: 20ms
+`), &ds))
+	timeouts := testutil.ToFloat64(websocketKeepaliveTimeouts.WithLabelValues("", "keepalive"))
+	stream, err := ds.GetLineStream(context.Background(), log.NewNopLogger())
+	tt.AssertNoError(err)
+	defer stream.Close()
+	_, err = stream.ReadLine()
+	tt.AssertNotEqual(nil, err)
+	tt.AssertEqual(timeouts+1, testutil.ToFloat64(websocketKeepaliveTimeouts.WithLabelValues("", "keepalive")))
+	tt.AssertEqual(float64(0), testutil.ToFloat64(websocketConnections.WithLabelValues("", "keepalive")))
+
+	var wsConfig Datasource
+	tt.AssertNotEqual(nil, yaml.Unmarshal([]byte("url: ws://127.0.0.1\nread_mode: stream\nconfig:\n  body: x\n"), &wsConfig))
+	tt.AssertNotEqual(nil, yaml.Unmarshal([]byte("url: ws://127.0.0.1\nread_mode: stream\nconfig:\n  ping_interval: -1s\n"), &wsConfig))
+	// the server may keep the connection open, full mode reads until end_of
+	var fullDs, streamDs Datasource
+	tt.AssertNotEqual(nil, yaml.Unmarshal([]byte("url: ws://127.0.0.1\n"), &fullDs))
+	tt.AssertNoError(yaml.Unmarshal([]byte("url: ws://127.0.0.1\nread_mode: stream\n"), &streamDs))
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	tt := testings.NewTesting(t)
+	ts := newWebSocketServer(t, func(conn *wsConn) {
+		// a frame with a 64-bit length, without payload
+		_, _ = conn.rwc.Write([]byte{0x80 | wsText, 127, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+		_, _ = conn.readMessage()
+	})
+	defer ts.Close()
+	wsConfig := NewWebSocketConfig()
+	stream, err := wsConfig.GetStream(context.Background(), "limits", strings.Replace(ts.URL, "http://", "ws://", 1))
+	tt.AssertNoError(err)
+	defer stream.Close()
+	_, err = io.ReadAll(stream)
+	tt.AssertNotEqual(nil, err)
+	tt.AssertEqual(true, strings.Contains(err.Error(), "websocket message is too large"))
+
+	// the handshake is bounded by max_connect_time
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+	wsConfig.MaxConnectTime = time.Millisecond * 100
+	start := time.Now()
+	_, err = wsConfig.GetStream(context.Background(), "limits", strings.Replace(hanging.URL, "http://", "ws://", 1))
+	tt.AssertNotEqual(nil, err)
+	tt.AssertEqual(true, time.Since(start) < time.Second*5)
+}
@@ -0,0 +1,395 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"gopkg.in/fsnotify.v1"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	DefaultTargetParallelism = 10
+	LabelAddress             = "__address__"
+	LabelInstance            = "instance"
+)
+
+var (
+	DefaultFileSDRefreshInterval = time.Minute * 5
+	DefaultHTTPSDRefreshInterval = time.Minute
+)
+
+// TargetGroup is a list of targets with common labels, in the format of Prometheus file_sd and http_sd.
+type TargetGroup struct {
+	Targets []string          `yaml:"targets" json:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+func (tg *TargetGroup) verify() error {
+	for name := range tg.Labels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("%q is not a valid label name", name)
+		}
+	}
+	return nil
+}
+
+type FileSDConfig struct {
+	// Files are the files (glob patterns are supported) that contain the target groups in JSON or YAML format.
+	Files           []string      `yaml:"files"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+func (c *FileSDConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain FileSDConfig
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if len(c.Files) == 0 {
+		return fmt.Errorf("file_sd_configs must contain at least one file")
+	}
+	for _, file := range c.Files {
+		if _, err := filepath.Match(file, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %s", file, err)
+		}
+		switch ext := filepath.Ext(file); ext {
+		case ".json", ".yml", ".yaml":
+		default:
+			return fmt.Errorf("invalid file extension of %q: must be .json, .yml or .yaml", file)
+		}
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = DefaultFileSDRefreshInterval
+	} else if c.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval cannot be negative: %s", c.RefreshInterval)
+	}
+	return nil
+}
+
+type HTTPSDConfig struct {
+	HTTPClientConfig promconfig.HTTPClientConfig `yaml:"http_client_config,inline"`
+	URL              string                      `yaml:"url"`
+	RefreshInterval  time.Duration               `yaml:"refresh_interval,omitempty"`
+}
+
+func (c *HTTPSDConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain HTTPSDConfig
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("invalid http_sd_configs url %q: scheme must be http or https", c.URL)
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = DefaultHTTPSDRefreshInterval
+	} else if c.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval cannot be negative: %s", c.RefreshInterval)
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+// Target is a discovered target, its labels are added to the datapoints collected from it.
+type Target struct {
+	Address string
+	Labels  map[string]string
+}
+
+// targetDiscovery keeps the target groups of each discovery source.
+type targetDiscovery struct {
+	mux    sync.RWMutex
+	groups map[string][]*TargetGroup
+}
+
+func (td *targetDiscovery) set(source string, groups []*TargetGroup) {
+	td.mux.Lock()
+	defer td.mux.Unlock()
+	td.groups[source] = groups
+}
+
+// Targets returns the targets of all sources, ordered by source. Duplicate targets (same address and labels)
+// are only returned once.
+func (td *targetDiscovery) Targets() []Target {
+	td.mux.RLock()
+	defer td.mux.RUnlock()
+	sources := make([]string, 0, len(td.groups))
+	for source := range td.groups {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var targets []Target
+	seen := map[uint64]bool{}
+	for _, source := range sources {
+		for _, group := range td.groups[source] {
+			for _, address := range group.Targets {
+				labels := make(map[string]string, len(group.Labels)+2)
+				labels[LabelInstance] = address
+				for name, value := range group.Labels {
+					labels[name] = value
+				}
+				labels[LabelAddress] = address
+				if hash := FromMap(labels).Hash(); !seen[hash] {
+					seen[hash] = true
+					targets = append(targets, Target{Address: address, Labels: labels})
+				}
+			}
+		}
+	}
+	return targets
+}
+
+func parseTargetGroups(name string, data []byte) ([]*TargetGroup, error) {
+	var groups []*TargetGroup
+	var err error
+	switch filepath.Ext(name) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &groups)
+	default:
+		err = json.Unmarshal(data, &groups)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if err = group.verify(); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// run reads the files, and reads them again when they are changed or at the refresh interval.
+func (c *FileSDConfig) run(ctx context.Context, logger log.Logger, td *targetDiscovery, source string) {
+	// the groups of the files that failed to be read are kept
+	fileGroups := map[string][]*TargetGroup{}
+	refresh := func() {
+		current := map[string][]*TargetGroup{}
+		for _, pattern := range c.Files {
+			files, _ := filepath.Glob(pattern)
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err == nil {
+					current[file], err = parseTargetGroups(file, data)
+				}
+				if err != nil {
+					collectErrorCount.WithLabelValues("discovery", source).Inc()
+					level.Error(logger).Log("msg", "failed to read file_sd file", "file", file, "err", err)
+					current[file] = fileGroups[file]
+				}
+			}
+		}
+		fileGroups = current
+		var groups []*TargetGroup
+		for _, pattern := range c.Files {
+			files, _ := filepath.Glob(pattern)
+			for _, file := range files {
+				groups = append(groups, fileGroups[file]...)
+			}
+		}
+		td.set(source, groups)
+	}
+	refresh()
+
+	var events chan fsnotify.Event
+	var errors chan error
+	if watcher, err := fsnotify.NewWatcher(); err != nil {
+		level.Error(logger).Log("msg", "failed to watch file_sd files, only refresh periodically", "err", err)
+	} else {
+		defer watcher.Close()
+		events, errors = watcher.Events, watcher.Errors
+		dirs := map[string]bool{}
+		for _, pattern := range c.Files {
+			dir := filepath.Dir(pattern)
+			if !dirs[dir] {
+				dirs[dir] = true
+				if err = watcher.Add(dir); err != nil {
+					level.Error(logger).Log("msg", "failed to watch file_sd directory", "dir", dir, "err", err)
+				}
+			}
+		}
+	}
+	ticker := time.NewTicker(c.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		case event := <-events:
+			for _, pattern := range c.Files {
+				if matched, _ := filepath.Match(pattern, event.Name); matched {
+					level.Debug(logger).Log("msg", "file_sd file changed", "file", event.Name, "op", event.Op)
+					refresh()
+					break
+				}
+			}
+		case err := <-errors:
+			level.Error(logger).Log("msg", "error watching file_sd files", "err", err)
+		}
+	}
+}
+
+// run requests the url at the refresh interval, the previous targets are kept if the request failed.
+func (c *HTTPSDConfig) run(ctx context.Context, logger log.Logger, td *targetDiscovery, source string) {
+	client, err := promconfig.NewClientFromConfig(c.HTTPClientConfig, "http_sd")
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to create http_sd client", "err", err)
+		return
+	}
+	refresh := func() error {
+		reqCtx, cancel := context.WithTimeout(ctx, c.RefreshInterval)
+		defer cancel()
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, c.URL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("server returned HTTP status %s", resp.Status)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, DefaultMaxContent))
+		if err != nil {
+			return err
+		}
+		groups, err := parseTargetGroups(".json", bytes.TrimSpace(data))
+		if err != nil {
+			return err
+		}
+		td.set(source, groups)
+		return nil
+	}
+	ticker := time.NewTicker(c.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err = refresh(); err != nil && ctx.Err() == nil {
+			collectErrorCount.WithLabelValues("discovery", source).Inc()
+			level.Error(logger).Log("msg", "failed to refresh http_sd targets", "url", c.URL, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *CollectConfig) hasDiscovery() bool {
+	return len(c.StaticConfigs) > 0 || len(c.FileSDConfigs) > 0 || len(c.HTTPSDConfigs) > 0
+}
+
+// StartDiscovery starts the file_sd and http_sd of the collect, until the context is done.
+func (c *CollectConfig) StartDiscovery(ctx context.Context) {
+	if c.discovery == nil {
+		return
+	}
+	for i, sd := range c.FileSDConfigs {
+		go sd.run(ctx, c.logger, c.discovery, fmt.Sprintf("%s/file_sd/%d", c.Name, i))
+	}
+	for i, sd := range c.HTTPSDConfigs {
+		go sd.run(ctx, c.logger, c.discovery, fmt.Sprintf("%s/http_sd/%d", c.Name, i))
+	}
+}
+
+// withTarget returns a copy of the datasource that reads the target, the labels of the target are added to the
+// datapoints. If the url of the datasource is a template (e.g. "http://{{ .__address__ }}/metrics"), it is
+// executed with the labels of the target, otherwise the url is replaced by the address of the target.
+func (d *Datasource) withTarget(target Target) (*Datasource, error) {
+	ds := *d
+	ds.Url = target.Address
+	ds.cache = nil
+	if strings.Contains(d.Url, "{{") {
+		tmpl, err := template.New("url").Funcs(safeFuncMap).Parse(d.Url)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, target.Labels); err != nil {
+			return nil, err
+		}
+		ds.Url = buf.String()
+	}
+	names := make([]string, 0, len(target.Labels))
+	for name := range target.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// the target labels are set before the relabel_configs of the collect
+	rcs := make(RelabelConfigs, 0, len(d.implicitRelabelConfigs)+len(names))
+	rcs = append(rcs, d.implicitRelabelConfigs...)
+	for _, name := range names {
+		rc := DefaultRelabelConfig
+		rc.TargetLabel = name
+		rc.Replacement = strings.ReplaceAll(target.Labels[name], "$", "$$")
+		rcs = append(rcs, &rc)
+	}
+	ds.implicitRelabelConfigs = rcs
+	return &ds, nil
+}
+
+// collectTargets reads the datasource for each discovered target, at most TargetParallelism targets concurrently.
+func (c *CollectContext) collectTargets(ds *Datasource, metrics chan<- MetricGenerator) {
+	sem := make(chan struct{}, c.TargetParallelism)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	// the read of the datasource succeeds if the reads of all targets succeed
+	var failed error
+	var mux sync.Mutex
+	for _, target := range c.discovery.Targets() {
+		targetDs, err := ds.withTarget(target)
+		if err != nil {
+			collectErrorCount.WithLabelValues("datasource", ds.Name).Inc()
+			level.Error(c.logger).Log("msg", "failed to build datasource of target", "datasource", ds.Name, "target", target.Address, "err", err)
+			mux.Lock()
+			failed = err
+			mux.Unlock()
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.getMetricByDs(c.Context, c.logger, targetDs, metrics); err != nil {
+				mux.Lock()
+				failed = err
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	observeScrape(c.Name, ds.Name, start, failed)
+}
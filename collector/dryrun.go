@@ -0,0 +1,306 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/MicroOps-cn/data_exporter/pkg/wrapper"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"io"
+)
+
+// DryRunResult records every stage of a collect run by DryRun.
+type DryRunResult struct {
+	Inputs []*DryRunInput `json:"inputs"`
+	// Exposition is the final output of the collect, in the text exposition format.
+	Exposition string   `json:"exposition"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// DryRunInput is the data read from a datasource (a line in line and stream mode).
+type DryRunInput struct {
+	Datasource string          `json:"datasource"`
+	Url        string          `json:"url,omitempty"`
+	RawData    string          `json:"raw_data"`
+	Metrics    []*DryRunMetric `json:"metrics"`
+}
+
+type DryRunMetric struct {
+	Name       string          `json:"name"`
+	MetricType MetricType      `json:"metric_type"`
+	Datapoints []Datapoint     `json:"datapoints"`
+	Series     []*DryRunSeries `json:"series"`
+	Exposition string          `json:"exposition"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+// DryRunSeries is a datapoint through the relabel_configs.
+type DryRunSeries struct {
+	Labels       Labels               `json:"labels"`
+	RelabelSteps []*DryRunRelabelStep `json:"relabel_steps"`
+	// FinalLabels are the labels after relabeling, nil if the series is dropped.
+	FinalLabels Labels `json:"final_labels"`
+	Error       string `json:"error,omitempty"`
+}
+
+// DryRunRelabelStep is a RelabelStep with the position of the relabel_config in the collect, and the labels after it.
+type DryRunRelabelStep struct {
+	// Source is the position of the relabel_config in the collect, e.g. "metrics[0].relabel_configs[1]".
+	Source string `json:"source"`
+	RelabelStep
+	Labels Labels `json:"labels"`
+}
+
+type metricsCollector []prometheus.Metric
+
+func (metricsCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (mc metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range mc {
+		ch <- m
+	}
+}
+
+// exposition encodes the metrics in the text exposition format.
+func exposition(metrics []prometheus.Metric) (string, error) {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(metricsCollector(metrics)); err != nil {
+		return "", err
+	}
+	families, err := reg.Gather()
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, e := expfmt.MetricFamilyToText(&buf, family); e != nil && err == nil {
+			err = e
+		}
+	}
+	return buf.String(), err
+}
+
+// applyDiff returns a copy of the labels with the removed labels deleted and the added labels set.
+func applyDiff(labels, added, removed Labels) Labels {
+	lb := NewBuilder(labels)
+	for _, l := range removed {
+		lb.Del(l.Name)
+	}
+	for _, l := range added {
+		lb.Set(l.Name, l.Value)
+	}
+	return lb.Labels()
+}
+
+type relabelSource struct {
+	source string
+	rc     *RelabelConfig
+}
+
+func relabelSources(prefix string, rcs RelabelConfigs) []relabelSource {
+	sources := make([]relabelSource, 0, len(rcs))
+	for idx, rc := range rcs {
+		sources = append(sources, relabelSource{source: fmt.Sprintf("%srelabel_configs[%d]", prefix, idx), rc: rc})
+	}
+	return sources
+}
+
+// readDryRunData reads the data of the datasource, split into lines in line mode.
+func readDryRunData(ctx context.Context, logger log.Logger, ds *Datasource) ([][]byte, error) {
+	if ds.ReadMode == Stream {
+		return nil, fmt.Errorf("inline data is required by the datasource in stream mode")
+	} else if ds.ReadMode == Full {
+		data, err := ds.ReadAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+	stream, err := ds.GetLineStream(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	var lines [][]byte
+	for {
+		line, err := stream.ReadLine()
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+// dryRunData runs the metrics of the collect on the data, the series are also added to the merger of the collect.
+func (c *CollectConfig) dryRunData(logger log.Logger, dsIdx int, ds *Datasource, data []byte, all *seriesMerger) *DryRunInput {
+	input := &DryRunInput{Datasource: ds.Name, Url: ds.Url, RawData: string(data)}
+	dsPrefix := fmt.Sprintf("datasource[%d].", dsIdx)
+	// the implicit relabel_configs are added by the exporter, e.g. the path label of the files matched by a glob
+	// pattern or the labels of the discovered target
+	sources := relabelSources(dsPrefix+"implicit_", ds.implicitRelabelConfigs)
+	sources = append(sources, relabelSources("", c.RelabelConfigs)...)
+	sources = append(sources, relabelSources(dsPrefix, ds.RelabelConfigs)...)
+	for mIdx, mc := range c.Metrics {
+		metric := &DryRunMetric{Name: mc.Name, MetricType: mc.MetricType}
+		metricSources := append(sources[:len(sources):len(sources)], relabelSources(fmt.Sprintf("metrics[%d].", mIdx), mc.RelabelConfigs)...)
+		rcs := make(RelabelConfigs, 0, len(metricSources))
+		for _, source := range metricSources {
+			rcs = append(rcs, source.rc)
+		}
+		metric.Datapoints = c.getDatapoints(logger, mc, data)
+		merger := newSeriesMerger(false)
+		for _, dp := range metric.Datapoints {
+			m := c.newMetricGenerator(logger, mc, dp)
+			series := &DryRunSeries{Labels: m.Labels.Copy()}
+			metric.Series = append(metric.Series, series)
+			labels, steps, err := rcs.Trace(m.Labels)
+			stepLabels := m.Labels
+			for _, step := range steps {
+				if step.Error != "" || step.Dropped {
+					stepLabels = nil
+				} else {
+					stepLabels = applyDiff(stepLabels, step.Added, step.Removed)
+				}
+				series.RelabelSteps = append(series.RelabelSteps, &DryRunRelabelStep{Source: metricSources[step.Index].source, RelabelStep: step, Labels: stepLabels})
+			}
+			if err == nil && labels != nil {
+				labels, err = mc.Relabels(logger, nil, labels)
+			}
+			if err != nil {
+				series.Error = err.Error()
+				metric.Errors = append(metric.Errors, err.Error())
+				continue
+			} else if labels == nil {
+				continue
+			}
+			m.Labels = labels
+			series.FinalLabels = labels.Copy()
+			ms, errs := m.prometheusMetrics()
+			for _, pm := range ms {
+				if err = merger.add(&m, pm); err == nil {
+					err = all.add(&m, pm)
+				}
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			for _, err = range errs {
+				series.Error = err.Error()
+				metric.Errors = append(metric.Errors, err.Error())
+			}
+		}
+		var err error
+		if metric.Exposition, err = exposition(merger.metrics()); err != nil {
+			metric.Errors = append(metric.Errors, err.Error())
+		}
+		input.Metrics = append(input.Metrics, metric)
+	}
+	return input
+}
+
+// DryRun runs the collect end to end, and records the raw data, the datapoints, the labels after each relabel step
+// and the output of every metric. The data maps the names of the datasources to inline data, the other datasources
+// are read. The lines of the datasources in stream mode are handled like in line mode.
+func (c *CollectConfig) DryRun(ctx context.Context, data map[string]string) *DryRunResult {
+	result := &DryRunResult{}
+	all := newSeriesMerger(false)
+	for dsIdx, ds := range c.Datasource {
+		logger := log.With(c.logger, "datasource", ds.Name)
+		datasources := []*Datasource{ds}
+		if files, ok, err := ds.globFiles(); ok {
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("datasource[%d]: %s", dsIdx, err))
+			}
+			datasources = datasources[:0]
+			for _, file := range files {
+				datasources = append(datasources, ds.withFile(file))
+			}
+		}
+		for _, d := range datasources {
+			var chunks [][]byte
+			if inline, ok := data[ds.Name]; ok {
+				chunks = [][]byte{[]byte(inline)}
+				if d.ReadMode != Full {
+					chunks = splitLines(chunks[0], d.LineSeparator)
+				}
+			} else {
+				var err error
+				readCtx, cancel := context.WithTimeout(ctx, d.Timeout)
+				chunks, err = readDryRunData(readCtx, logger, d)
+				cancel()
+				if err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("datasource[%d]: failed to read data: %s", dsIdx, err))
+				}
+			}
+			for _, chunk := range chunks {
+				input := c.dryRunData(logger, dsIdx, d, chunk, all)
+				result.Inputs = append(result.Inputs, input)
+			}
+			if _, ok := data[ds.Name]; ok {
+				// the inline data is used once for the files matched by a glob pattern
+				break
+			}
+		}
+	}
+	var err error
+	if result.Exposition, err = exposition(all.metrics()); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	return result
+}
+
+// WriteTrace writes the relabel steps of every series, to find out by which relabel_config a series is dropped.
+func (r *DryRunResult) WriteTrace(w io.Writer) error {
+	var buf bytes.Buffer
+	for _, input := range r.Inputs {
+		fmt.Fprintf(&buf, "datasource %q (%s): %q\n", input.Datasource, input.Url, wrapper.Limit[byte]([]byte(input.RawData), 64, wrapper.PosCenter, []byte(" ... ")...))
+		for _, metric := range input.Metrics {
+			fmt.Fprintf(&buf, "  metric %q: %d datapoints\n", metric.Name, len(metric.Datapoints))
+			for _, series := range metric.Series {
+				fmt.Fprintf(&buf, "    series %s\n", series.Labels)
+				for _, step := range series.RelabelSteps {
+					fmt.Fprintf(&buf, "      %s %s: value=%q matched=%t", step.Source, step.Action, step.Value, step.Matched)
+					if len(step.Added) > 0 {
+						fmt.Fprintf(&buf, " added=%s", step.Added)
+					}
+					if len(step.Removed) > 0 {
+						fmt.Fprintf(&buf, " removed=%s", step.Removed)
+					}
+					if step.Dropped {
+						buf.WriteString(" dropped")
+					}
+					if len(step.Error) > 0 {
+						fmt.Fprintf(&buf, " error=%q", step.Error)
+					}
+					buf.WriteString("\n")
+				}
+				if len(series.Error) > 0 {
+					fmt.Fprintf(&buf, "    => error: %s\n", series.Error)
+				} else if series.FinalLabels == nil && len(series.RelabelSteps) > 0 {
+					fmt.Fprintf(&buf, "    => dropped by %s\n", series.RelabelSteps[len(series.RelabelSteps)-1].Source)
+				} else {
+					fmt.Fprintf(&buf, "    => %s\n", series.FinalLabels)
+				}
+			}
+		}
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&buf, "error: %s\n", err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"strings"
+)
+
+// DuplicatePolicy is how the series of a metric with the same name and labels are merged.
+type DuplicatePolicy string
+
+const (
+	// DuplicateError keeps the first series, and reports the duplicates as errors.
+	DuplicateError DuplicatePolicy = "error"
+	// DuplicateFirst keeps the first series.
+	DuplicateFirst DuplicatePolicy = "first"
+	// DuplicateLast keeps the last series.
+	DuplicateLast DuplicatePolicy = "last"
+	// DuplicateSum, DuplicateMax, DuplicateMin and DuplicateAvg merge the values of the series, they only apply to
+	// gauges, counters and untyped metrics, the first series of the other types is kept.
+	DuplicateSum DuplicatePolicy = "sum"
+	DuplicateMax DuplicatePolicy = "max"
+	DuplicateMin DuplicatePolicy = "min"
+	DuplicateAvg DuplicatePolicy = "avg"
+)
+
+func (p DuplicatePolicy) verify(metricType MetricType) error {
+	switch p {
+	case "", DuplicateError, DuplicateFirst, DuplicateLast:
+	case DuplicateSum, DuplicateMax, DuplicateMin, DuplicateAvg:
+		if metricType = metricType.ToLower(); metricType == Histogram || metricType == Summary {
+			return fmt.Errorf("duplicate_policy %s is not supported by %s", p, metricType)
+		}
+	default:
+		return fmt.Errorf("unknown duplicate_policy: %s", p)
+	}
+	return nil
+}
+
+var duplicateSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: ExporterName,
+	Name:      "duplicate_series_total",
+	Help:      "number of series merged into a series with the same name and labels according to the duplicate_policy of the metric",
+}, []string{"collect", "metric", "policy"})
+
+// mergedSeries is a series with the values of its duplicates merged.
+type mergedSeries struct {
+	prometheus.Metric
+	policy DuplicatePolicy
+	value  float64
+	count  int
+}
+
+func scalarValue(m *dto.Metric) (float64, bool) {
+	if m.Gauge != nil {
+		return m.Gauge.GetValue(), true
+	} else if m.Counter != nil {
+		return m.Counter.GetValue(), true
+	} else if m.Untyped != nil {
+		return m.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+func (s *mergedSeries) Write(out *dto.Metric) error {
+	if err := s.Metric.Write(out); err != nil {
+		return err
+	}
+	value := s.value
+	if s.policy == DuplicateAvg {
+		value /= float64(s.count)
+	}
+	if out.Gauge != nil {
+		out.Gauge.Value = &value
+	} else if out.Counter != nil {
+		out.Counter.Value = &value
+	} else if out.Untyped != nil {
+		out.Untyped.Value = &value
+	}
+	return nil
+}
+
+// seriesMerger merges the series with the same name and labels according to the duplicate_policy of their metric,
+// so that the registry does not fail the scrape because of duplicates.
+type seriesMerger struct {
+	series []*mergedSeries
+	index  map[string]*mergedSeries
+	// label names of the series of every metric name
+	labelNames map[string]string
+	// observe counts the duplicates in duplicateSeries
+	observe bool
+}
+
+func newSeriesMerger(observe bool) *seriesMerger {
+	return &seriesMerger{index: map[string]*mergedSeries{}, labelNames: map[string]string{}, observe: observe}
+}
+
+// add adds the metric of the generator, the returned error reports a series that is dropped because its label
+// names are different from the ones of the previous series of the same name, or a duplicate with the error policy.
+func (sm *seriesMerger) add(m *MetricGenerator, metric prometheus.Metric) error {
+	opts, err := m.getOpts()
+	if err != nil {
+		return err
+	}
+	var pb dto.Metric
+	if err = metric.Write(&pb); err != nil {
+		return err
+	}
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	names := make([]string, 0, len(pb.Label))
+	key := []string{name}
+	for _, pair := range pb.Label {
+		names = append(names, pair.GetName())
+		key = append(key, pair.GetName(), pair.GetValue())
+	}
+	labelNames := strings.Join(names, ",")
+	if previous, ok := sm.labelNames[name]; !ok {
+		sm.labelNames[name] = labelNames
+	} else if previous != labelNames {
+		return fmt.Errorf("series of metric %s has label names [%s] instead of [%s] of the previous series", name, labelNames, previous)
+	}
+
+	var policy DuplicatePolicy
+	var collect, metricName = "", m.Name
+	if m.Datapoint != nil {
+		policy, collect, metricName = m.Datapoint.DuplicatePolicy, m.Datapoint.collect, m.Datapoint.Name
+	}
+	if len(policy) == 0 {
+		policy = DuplicateError
+	}
+	value, scalar := scalarValue(&pb)
+	series, ok := sm.index[strings.Join(key, "\xff")]
+	if !ok {
+		series = &mergedSeries{Metric: metric, policy: policy, value: value, count: 1}
+		sm.index[strings.Join(key, "\xff")] = series
+		sm.series = append(sm.series, series)
+		return nil
+	}
+	if sm.observe {
+		duplicateSeries.WithLabelValues(collect, metricName, string(policy)).Inc()
+	}
+	series.count++
+	switch series.policy {
+	case DuplicateError:
+		return fmt.Errorf("duplicate series of metric %s: {%s}", name, strings.Join(key[1:], ","))
+	case DuplicateLast:
+		series.Metric, series.value = metric, value
+	case DuplicateSum, DuplicateAvg:
+		series.value += value
+	case DuplicateMax:
+		series.value = math.Max(series.value, value)
+	case DuplicateMin:
+		series.value = math.Min(series.value, value)
+	}
+	if !scalar && series.policy != DuplicateLast {
+		series.policy = DuplicateFirst
+	}
+	return nil
+}
+
+// metrics returns the merged series, in the order in which they were added.
+func (sm *seriesMerger) metrics() []prometheus.Metric {
+	metrics := make([]prometheus.Metric, 0, len(sm.series))
+	for _, series := range sm.series {
+		if series.count == 1 || series.policy == DuplicateError || series.policy == DuplicateFirst || series.policy == DuplicateLast {
+			metrics = append(metrics, series.Metric)
+		} else {
+			metrics = append(metrics, series)
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/MicroOps-cn/data_exporter/pkg/wrapper"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+const DefaultExecMaxStderrLength = 4096
+
+// execEnabled allows exec datasources, they run commands on the exporter host so they must be explicitly enabled.
+var execEnabled bool
+
+// EnableExec allows exec datasources without --collector.exec.enable, for the commands that never run them.
+func EnableExec() {
+	execEnabled = true
+}
+
+var (
+	execExitCode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "exec_exit_code",
+		Help:      "exit code of the last command run by exec datasource, -1 if the command was killed or failed to start",
+	}, []string{"name"})
+	execStderrBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "exec_stderr_bytes",
+		Help:      "number of bytes written to stderr by the last command run by exec datasource",
+	}, []string{"name"})
+)
+
+type ExecConfig struct {
+	Args            []string          `yaml:"args,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty"`
+	WorkingDir      string            `yaml:"working_dir,omitempty"`
+	ValidExitCodes  []int             `yaml:"valid_exit_codes,omitempty"`
+	MaxStderrLength int               `yaml:"max_stderr_length,omitempty"`
+}
+
+func (e ExecConfig) GetStream(ctx context.Context, name, command string) (io.ReadCloser, error) {
+	logger, ok := ctx.Value(LoggerContextName).(log.Logger)
+	if !ok {
+		logger = log.NewNopLogger()
+	}
+	cmd := exec.CommandContext(ctx, command, e.Args...)
+	cmd.Dir = e.WorkingDir
+	if len(e.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, val := range e.Env {
+			cmd.Env = append(cmd.Env, key+"="+val)
+		}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &limitedBuffer{max: e.MaxStderrLength}
+	if stderr.max <= 0 {
+		stderr.max = DefaultExecMaxStderrLength
+	}
+	cmd.Stderr = stderr
+	if err = cmd.Start(); err != nil {
+		execExitCode.WithLabelValues(name).Set(-1)
+		return nil, err
+	}
+	validExitCodes := e.ValidExitCodes
+	if len(validExitCodes) == 0 {
+		validExitCodes = []int{0}
+	}
+	return &ExecReader{
+		ReadCloser:     stdout,
+		cmd:            cmd,
+		name:           name,
+		stderr:         stderr,
+		logger:         logger,
+		validExitCodes: validExitCodes,
+	}, nil
+}
+
+// ExecReader reads the stdout of a running command. The command is waited for when
+// stdout reaches EOF or the reader is closed, and its exit code is recorded at that time.
+type ExecReader struct {
+	io.ReadCloser
+	cmd            *exec.Cmd
+	name           string
+	stderr         *limitedBuffer
+	logger         log.Logger
+	validExitCodes []int
+	// mux protects killed and exited, the command may be waited for by Read while Close is called
+	mux    sync.Mutex
+	killed bool
+	exited bool
+	once   sync.Once
+	err    error
+}
+
+func (r *ExecReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *ExecReader) Close() error {
+	r.mux.Lock()
+	if !r.exited && r.cmd.Process != nil {
+		r.killed = true
+		_ = r.cmd.Process.Kill()
+	}
+	r.mux.Unlock()
+	_ = r.wait()
+	return nil
+}
+
+func (r *ExecReader) wait() error {
+	r.once.Do(func() {
+		_ = r.cmd.Wait()
+		r.mux.Lock()
+		r.exited = true
+		killed := r.killed
+		r.mux.Unlock()
+		exitCode := r.cmd.ProcessState.ExitCode()
+		execExitCode.WithLabelValues(r.name).Set(float64(exitCode))
+		execStderrBytes.WithLabelValues(r.name).Set(float64(r.stderr.size))
+		if r.stderr.buf.Len() > 0 {
+			level.Warn(r.logger).Log("msg", "command wrote to stderr", "command", r.cmd.Path, "exit_code", exitCode, "stderr", string(wrapper.Limit[byte](r.stderr.buf.Bytes(), 256, wrapper.PosCenter, []byte(" ... ")...)))
+		}
+		if killed {
+			return
+		}
+		for _, code := range r.validExitCodes {
+			if code == exitCode {
+				return
+			}
+		}
+		r.err = fmt.Errorf("invalid exit code: %d not in %v", exitCode, r.validExitCodes)
+		level.Error(r.logger).Log("msg", "command exited abnormally", "command", r.cmd.Path, "err", r.err)
+	})
+	return r.err
+}
+
+// limitedBuffer keeps at most max bytes of the written data, while still counting the total size.
+type limitedBuffer struct {
+	buf  bytes.Buffer
+	max  int
+	size int
+	mux  sync.Mutex
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.size += len(p)
+	if remain := b.max - b.buf.Len(); remain > 0 {
+		if len(p) > remain {
+			b.buf.Write(p[:remain])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
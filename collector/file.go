@@ -0,0 +1,76 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultFilePathLabel = "path"
+
+// fileRescanInterval is the interval at which the glob pattern of stream file datasources is evaluated again.
+var fileRescanInterval = time.Second * 10
+
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globPattern returns the glob pattern of the file datasource, the url itself or the files of the directory. ok is
+// false if the url is a single file.
+func (d *Datasource) globPattern() (pattern string, ok bool) {
+	if d.Type != File || d.expanded {
+		return "", false
+	}
+	if isGlobPattern(d.Url) {
+		return d.Url, true
+	}
+	if stat, err := os.Stat(d.Url); err != nil || !stat.IsDir() {
+		return "", false
+	}
+	return filepath.Join(d.Url, "*"), true
+}
+
+// globFiles returns the regular files matched by the url of the file datasource when it is a glob pattern or a
+// directory. ok is false if the url is a single file.
+func (d *Datasource) globFiles() (files []string, ok bool, err error) {
+	pattern, ok := d.globPattern()
+	if !ok {
+		return nil, false, nil
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, true, err
+	}
+	for _, match := range matches {
+		if stat, err := os.Stat(match); err == nil && stat.Mode().IsRegular() {
+			files = append(files, match)
+		}
+	}
+	return files, true, nil
+}
+
+// withFile returns a copy of the datasource that reads the given file, the path of the file is set to the path_label.
+func (d *Datasource) withFile(path string) *Datasource {
+	ds := *d
+	ds.Url = path
+	ds.expanded = true
+	rc := DefaultRelabelConfig
+	rc.TargetLabel = d.PathLabel
+	rc.Replacement = strings.ReplaceAll(path, "$", "$$")
+	ds.implicitRelabelConfigs = append(d.implicitRelabelConfigs[:len(d.implicitRelabelConfigs):len(d.implicitRelabelConfigs)], &rc)
+	return &ds
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+// splitLines splits the data by the line separators, the trailing empty line is dropped.
+func splitLines(data []byte, separators []string) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		idx, sepLen := indexSeparator(data, separators)
+		if idx < 0 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:idx])
+		data = data[idx+sepLen:]
+	}
+	return lines
+}
+
+// indexSeparator returns the index and the length of the first separator in the data, the index is -1 if there is none.
+func indexSeparator(data []byte, separators []string) (idx, sepLen int) {
+	idx = -1
+	for _, sep := range separators {
+		if i := bytes.Index(data, []byte(sep)); len(sep) > 0 && i >= 0 && (idx < 0 || i < idx) {
+			idx, sepLen = i, len(sep)
+		}
+	}
+	return idx, sepLen
+}
+
+// CollectData collects the metrics from the given data of the datasources instead of reading them, the datasources
+// without data are skipped. The data of the datasources in line mode is split into lines, and the data of the
+// datasources in stream mode is handled like the lines read from the stream, into a new series group.
+func (c *CollectConfig) CollectData(data map[*Datasource][]byte, proMetrics chan<- prometheus.Metric) {
+	stream := &MetricGroup{name: c.Name, metrics: make(map[string]prometheus.Collector)}
+	streamMetrics := make(chan MetricGenerator, 10)
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		for metric := range streamMetrics {
+			if err := stream.handle(metric); err != nil {
+				level.Info(metric.logger).Log("msg", "failed to parse metric", "err", err)
+			}
+		}
+	}()
+	mgs := NewMetricGenerators(10, c.logger)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(mgs.metrics)
+		defer close(streamMetrics)
+		for _, ds := range c.Datasource {
+			content, ok := data[ds]
+			if !ok {
+				continue
+			}
+			logger := log.With(c.logger, "datasource", ds.Name)
+			rcs := c.relabelConfigs(ds)
+			switch ds.ReadMode {
+			case Line:
+				for _, line := range splitLines(content, ds.LineSeparator) {
+					c.GetMetric(logger, line, rcs, mgs.metrics)
+				}
+			case Stream:
+				for _, line := range splitLines(content, ds.LineSeparator) {
+					c.GetMetric(logger, line, rcs, streamMetrics)
+				}
+			default:
+				c.GetMetric(logger, content, rcs, mgs.metrics)
+			}
+		}
+	}()
+	mgs.Collect(proMetrics)
+	wg.Wait()
+	<-streamDone
+	stream.Collect(proMetrics)
+}
@@ -17,4 +17,7 @@ import "gopkg.in/alecthomas/kingpin.v2"
 
 func AddFlags(flagSet *kingpin.Application) {
 	flagSet.Flag("datasource.default-timeout", "Default timeout").Default("30s").DurationVar(&DatasourceDefaultTimeout)
+	flagSet.Flag("collector.exec.enable", "Allow exec datasources, which run local commands. Configurations with exec datasources fail to load if disabled.").Default("false").BoolVar(&execEnabled)
+	flagSet.Flag("file.positions-path", "Path of the file used to save the read offsets of stream file datasources, so that they can be resumed after a restart. Disabled if empty.").StringVar(&positionsPath)
+	flagSet.Flag("file.positions-sync-period", "Period at which the read offsets of stream file datasources are written to the positions file.").Default("10s").DurationVar(&positionsSyncPeriod)
 }
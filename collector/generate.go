@@ -0,0 +1,293 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/beevik/etree"
+	"github.com/prometheus/common/model"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type generatedConfig struct {
+	Collects []*generatedCollect `yaml:"collects"`
+}
+
+type generatedCollect struct {
+	Name       string                 `yaml:"name"`
+	DataFormat DataFormat             `yaml:"data_format"`
+	Datasource []*generatedDatasource `yaml:"datasource"`
+	Metrics    []*generatedMetric     `yaml:"metrics"`
+}
+
+type generatedDatasource struct {
+	Type DatasourceType `yaml:"type"`
+	Url  string         `yaml:"url"`
+}
+
+type generatedRelabelConfig struct {
+	Action Action `yaml:"action"`
+	Regex  string `yaml:"regex"`
+}
+
+type generatedMetric struct {
+	Name           string                    `yaml:"name"`
+	RelabelConfigs []*generatedRelabelConfig `yaml:"relabel_configs,omitempty"`
+	Match          struct {
+		Datapoint string            `yaml:"datapoint,omitempty"`
+		Labels    map[string]string `yaml:"labels"`
+	} `yaml:"match"`
+}
+
+// generatedGroup is a datapoint of the sample, with the keys of its numeric leaves and string siblings.
+type generatedGroup struct {
+	datapoint string
+	names     []string
+	values    []string
+	labels    []string
+	seen      map[string]bool
+}
+
+func (g *generatedGroup) add(key string, numeric bool) {
+	if g.seen[key] {
+		return
+	}
+	g.seen[key] = true
+	if numeric {
+		g.values = append(g.values, key)
+	} else {
+		g.labels = append(g.labels, key)
+	}
+}
+
+type generator struct {
+	groups  []*generatedGroup
+	byPoint map[string]*generatedGroup
+}
+
+func (g *generator) group(datapoint string, names []string) *generatedGroup {
+	group, ok := g.byPoint[datapoint]
+	if !ok {
+		group = &generatedGroup{datapoint: datapoint, names: names, seen: map[string]bool{}}
+		g.byPoint[datapoint] = group
+		g.groups = append(g.groups, group)
+	}
+	return group
+}
+
+// sanitizeName converts the string into a valid metric or label name.
+func sanitizeName(s string) string {
+	var b strings.Builder
+	lastUnderscore := true
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			lastUnderscore = false
+		} else if !lastUnderscore {
+			b.WriteByte('_')
+			lastUnderscore = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "_")
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// escapeGJsonKey escapes the special characters of the gjson path syntax in the key.
+func escapeGJsonKey(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		if strings.ContainsRune(`\.*?|#@!=<>%`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
+
+// walkJson finds the objects of the json value, the segments are the gjson path of the value ("#" for arrays).
+func (g *generator) walkJson(r gjson.Result, segments []string, names []string) {
+	if r.IsArray() {
+		for _, elem := range r.Array() {
+			g.walkJson(elem, append(segments[:len(segments):len(segments)], "#"), names)
+		}
+		return
+	} else if !r.IsObject() {
+		return
+	}
+	// the path of the objects of an array is the path of the array, nested arrays are flattened
+	path := segments
+	if len(path) > 0 && path[len(path)-1] == "#" {
+		path = path[:len(path)-1]
+	}
+	datapoint := strings.Join(path, ".")
+	arrays := 0
+	for _, segment := range segments {
+		if segment == "#" {
+			arrays++
+		}
+	}
+	for i := 1; i < arrays; i++ {
+		datapoint += "|@flatten"
+	}
+	group := g.group(datapoint, names)
+	r.ForEach(func(key, value gjson.Result) bool {
+		switch value.Type {
+		case gjson.JSON:
+			g.walkJson(value, append(segments[:len(segments):len(segments)], escapeGJsonKey(key.String())), append(names[:len(names):len(names)], key.String()))
+		case gjson.Number:
+			group.add(key.String(), true)
+		case gjson.String:
+			group.add(key.String(), isNumeric(value.String()))
+		case gjson.True, gjson.False:
+			group.add(key.String(), false)
+		}
+		return true
+	})
+}
+
+// walkXml finds the elements that have numeric leaf elements or attributes.
+func (g *generator) walkXml(elem *etree.Element, path string, names []string) {
+	path = path + "/" + elem.FullTag()
+	names = append(names[:len(names):len(names)], elem.Tag)
+	group := g.group(path, names)
+	if text := strings.TrimSpace(elem.Text()); len(text) > 0 {
+		group.add(".", isNumeric(text))
+	}
+	for _, attr := range elem.Attr {
+		group.add("@"+attr.FullKey(), isNumeric(attr.Value))
+	}
+	for _, child := range elem.ChildElements() {
+		if len(child.ChildElements()) > 0 || len(child.Attr) > 0 {
+			g.walkXml(child, path, names)
+		} else if text := strings.TrimSpace(child.Text()); len(text) > 0 {
+			group.add(child.FullTag(), isNumeric(text))
+		}
+	}
+}
+
+// xmlValueTemplate returns the template of the text of the element (key "."), an attribute (key "@name") or a child
+// element.
+func xmlValueTemplate(key string) string {
+	if key == "." {
+		return "{{ .Text }}"
+	} else if strings.HasPrefix(key, "@") {
+		return fmt.Sprintf("{{ .SelectAttrValue %q \"\" }}", key[1:])
+	}
+	return fmt.Sprintf("{{ with .FindElement %q }}{{ .Text }}{{ end }}", key)
+}
+
+// GenerateConfig infers a starter configuration from a sample payload in json, yaml or xml format. The numeric leaves
+// of the sample become metrics, and their string siblings become labels.
+func GenerateConfig(name string, format DataFormat, url string, data []byte) ([]byte, error) {
+	g := &generator{byPoint: map[string]*generatedGroup{}}
+	format = format.ToLower()
+	switch format {
+	case Json, Yaml:
+		if format == Yaml {
+			jsonData, err := yamlToJson(data, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse yaml data: %s", err)
+			}
+			data = jsonData
+		}
+		if !gjson.ValidBytes(data) {
+			return nil, fmt.Errorf("failed to parse json data")
+		}
+		g.walkJson(gjson.ParseBytes(data), nil, nil)
+	case Xml:
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(data); err != nil {
+			return nil, fmt.Errorf("failed to parse xml data: %s", err)
+		} else if doc.Root() == nil {
+			return nil, fmt.Errorf("failed to parse xml data: no root element")
+		}
+		g.walkXml(doc.Root(), "", nil)
+	default:
+		return nil, fmt.Errorf("unsupported data format: %s, must be json, yaml or xml", format)
+	}
+
+	collect := &generatedCollect{
+		Name:       name,
+		DataFormat: format,
+		Datasource: []*generatedDatasource{{Type: File, Url: url}},
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		collect.Datasource[0].Type = Http
+	}
+	metricNames := map[string]int{}
+	for _, group := range g.groups {
+		labels := map[string]string{}
+		for _, key := range group.labels {
+			labelName := sanitizeName(strings.Trim(key, "@."))
+			if _, ok := labels[labelName]; ok || !model.LabelName(labelName).IsValid() || strings.HasPrefix(labelName, "__") {
+				continue
+			}
+			if format == Xml {
+				labels[labelName] = xmlValueTemplate(key)
+			} else {
+				labels[labelName] = escapeGJsonKey(key)
+			}
+		}
+		for _, key := range group.values {
+			metric := &generatedMetric{}
+			metric.Name = sanitizeName(strings.Join(append(append([]string{name}, group.names...), strings.Trim(key, "@.")), "_"))
+			if metricNames[metric.Name]++; metricNames[metric.Name] > 1 {
+				metric.Name = fmt.Sprintf("%s_%d", metric.Name, metricNames[metric.Name])
+			}
+			metric.Match.Datapoint = group.datapoint
+			metric.Match.Labels = map[string]string{}
+			for labelName, match := range labels {
+				metric.Match.Labels[labelName] = match
+			}
+			if format == Xml {
+				metric.Match.Labels[LabelMetricValue] = xmlValueTemplate(key)
+			} else {
+				metric.Match.Labels[LabelMetricValue] = escapeGJsonKey(key)
+				// every key of a json datapoint is added as a label, only keep the proposed labels
+				keep := []string{"__.*", "name"}
+				for labelName := range labels {
+					if labelName != "name" {
+						keep = append(keep, labelName)
+					}
+				}
+				sort.Strings(keep[2:])
+				metric.RelabelConfigs = []*generatedRelabelConfig{{Action: LabelKeep, Regex: strings.Join(keep, "|")}}
+			}
+			collect.Metrics = append(collect.Metrics, metric)
+		}
+	}
+	if len(collect.Metrics) == 0 {
+		return nil, fmt.Errorf("no numeric value found in the sample")
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&generatedConfig{Collects: []*generatedCollect{collect}}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -223,44 +223,106 @@ func (c *RelabelConfig) UnmarshalYAML(value *yaml.Node) error {
 // If a label set is dropped, nil is returned.
 // May return the input labelSet modified.
 func (rcs RelabelConfigs) Process(labels Labels) (Labels, error) {
-	var err error
-	for _, rc := range rcs {
-		labels, err = relabel(labels, rc)
-		if labels == nil || err != nil {
+	return rcs.process(labels, nil)
+}
+
+// RelabelStep records the effect of a relabel configuration on a label set.
+type RelabelStep struct {
+	// Index is the index of the relabel configuration in the relabel configurations.
+	Index  int    `json:"index"`
+	Action Action `json:"action"`
+	// Value is the concatenation of the values of the source labels.
+	Value string `json:"value"`
+	// Matched reports whether the regex matched the value, or any label name for the labelmap, labeldrop and
+	// labelkeep actions. It is always true for the actions without regex.
+	Matched bool `json:"matched"`
+	// Added are the labels set by the relabel configuration, Removed the labels deleted or overwritten.
+	Added   Labels `json:"added,omitempty"`
+	Removed Labels `json:"removed,omitempty"`
+	Dropped bool   `json:"dropped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Trace is like Process, and also returns the steps of the relabel configurations applied,
+// the last step is the one that dropped the label set or failed.
+func (rcs RelabelConfigs) Trace(labels Labels) (Labels, []RelabelStep, error) {
+	var steps []RelabelStep
+	labels, err := rcs.process(labels, &steps)
+	return labels, steps, err
+}
+
+func (rcs RelabelConfigs) process(labels Labels, steps *[]RelabelStep) (Labels, error) {
+	for idx, rc := range rcs {
+		newLabels, matched, err := relabel(labels, rc)
+		if steps != nil {
+			step := RelabelStep{Index: idx, Action: rc.Action, Value: sourceValue(labels, rc), Matched: matched, Dropped: newLabels == nil && err == nil}
+			if err != nil {
+				step.Error = err.Error()
+			} else if newLabels != nil {
+				step.Added, step.Removed = diffLabels(labels, newLabels)
+			}
+			*steps = append(*steps, step)
+		}
+		if newLabels == nil || err != nil {
 			return nil, err
 		}
+		labels = newLabels
 	}
 	return labels, nil
 }
 
-func relabel(lset Labels, cfg *RelabelConfig) (Labels, error) {
+// diffLabels returns the labels of b that are not in a, and the labels of a that are not in b.
+func diffLabels(a, b Labels) (added, removed Labels) {
+	before, after := a.Map(), b.Map()
+	for _, l := range b {
+		if val, ok := before[l.Name]; !ok || val != l.Value {
+			added = append(added, l)
+		}
+	}
+	for _, l := range a {
+		if val, ok := after[l.Name]; !ok || val != l.Value {
+			removed = append(removed, l)
+		}
+	}
+	return added, removed
+}
+
+func sourceValue(lset Labels, cfg *RelabelConfig) string {
 	values := make([]string, 0, len(cfg.SourceLabels))
 	for _, ln := range cfg.SourceLabels {
 		values = append(values, lset.Get(string(ln)))
 	}
-	val := strings.Join(values, cfg.Separator)
+	return strings.Join(values, cfg.Separator)
+}
+
+// relabel applies the relabel configuration, and reports whether its regex matched.
+func relabel(lset Labels, cfg *RelabelConfig) (Labels, bool, error) {
+	val := sourceValue(lset, cfg)
+	matched := true
 
 	lb := NewBuilder(lset)
 
 	switch cfg.Action {
 	case Drop:
 		if cfg.Regex.MatchString(val) {
-			return nil, nil
+			return nil, true, nil
 		}
+		matched = false
 	case Keep:
 		if !cfg.Regex.MatchString(val) {
-			return nil, nil
+			return nil, false, nil
 		}
 	case TemplateExecute:
 		newVal, err := cfg.Template.Execute(val)
 		if err != nil {
-			return nil, fmt.Errorf("faile to execute template: %s,err: %s", cfg.Template.original, err)
+			return nil, matched, fmt.Errorf("faile to execute template: %s,err: %s", cfg.Template.original, err)
 		}
 		lb.Set(cfg.TargetLabel, string(newVal))
 	case Replace:
 		indexes := cfg.Regex.FindStringSubmatchIndex(val)
 		// If there is no match no replacement must take place.
 		if indexes == nil {
+			matched = false
 			break
 		}
 		target := model.LabelName(cfg.Regex.ExpandString([]byte{}, cfg.TargetLabel, val, indexes))
@@ -278,29 +340,36 @@ func relabel(lset Labels, cfg *RelabelConfig) (Labels, error) {
 		mod := sum64(md5.Sum([]byte(val))) % cfg.Modulus
 		lb.Set(cfg.TargetLabel, fmt.Sprintf("%d", mod))
 	case LabelMap:
+		matched = false
 		for _, l := range lset {
 			if cfg.Regex.MatchString(l.Name) {
+				matched = true
 				res := cfg.Regex.ReplaceAllString(l.Name, cfg.Replacement)
 				lb.Set(res, l.Value)
 			}
 		}
 	case LabelDrop:
+		matched = false
 		for _, l := range lset {
 			if cfg.Regex.MatchString(l.Name) {
+				matched = true
 				lb.Del(l.Name)
 			}
 		}
 	case LabelKeep:
+		matched = false
 		for _, l := range lset {
 			if !cfg.Regex.MatchString(l.Name) {
 				lb.Del(l.Name)
+			} else {
+				matched = true
 			}
 		}
 	default:
 		panic(fmt.Errorf("relabel: unknown relabel action type %q", cfg.Action))
 	}
 
-	return lb.Labels(), nil
+	return lb.Labels(), matched, nil
 }
 
 // sum64 sums the md5 hash to an uint64.
@@ -704,6 +773,14 @@ func (ls Labels) Keys() []string {
 	return keys
 }
 
+func (ls Labels) Values() []string {
+	var vals []string
+	for _, l := range ls {
+		vals = append(vals, l.Value)
+	}
+	return vals
+}
+
 func (ls *Labels) Append(name, val string) {
 	for idx, l := range *ls {
 		if l.Name == name {
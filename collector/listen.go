@@ -0,0 +1,418 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultListenMaxConnections = 100
+	DefaultListenMaxMessageSize = 65536
+	DefaultListenSourceLabel    = "source_address"
+)
+
+// ListenConfig is the config of the listen_udp, listen_tcp and listen_http datasources, which receive the data pushed
+// to the address of the url instead of reading it.
+type ListenConfig struct {
+	// MaxConnections is the maximum number of TCP connections, or of concurrent HTTP requests.
+	MaxConnections int `yaml:"max_connections,omitempty"`
+	// MaxMessageSize is the maximum size in bytes of a datagram, of a line received over TCP or of an HTTP request body.
+	MaxMessageSize int `yaml:"max_message_size,omitempty"`
+	// SourceLabel is the label that holds the address of the sender.
+	SourceLabel string `yaml:"source_label,omitempty"`
+	// Path is the path of the HTTP requests accepted by the listen_http datasource.
+	Path string `yaml:"path,omitempty"`
+}
+
+func (l *ListenConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain ListenConfig
+	if err := value.Decode((*plain)(l)); err != nil {
+		return err
+	}
+	return l.Verify()
+}
+
+// Verify sets the default values of the unset options and checks the others.
+func (l *ListenConfig) Verify() error {
+	if l.MaxConnections == 0 {
+		l.MaxConnections = DefaultListenMaxConnections
+	} else if l.MaxConnections < 0 {
+		return fmt.Errorf("max_connections cannot be negative: %d", l.MaxConnections)
+	}
+	if l.MaxMessageSize == 0 {
+		l.MaxMessageSize = DefaultListenMaxMessageSize
+	} else if l.MaxMessageSize < 0 {
+		return fmt.Errorf("max_message_size cannot be negative: %d", l.MaxMessageSize)
+	}
+	if len(l.SourceLabel) == 0 {
+		l.SourceLabel = DefaultListenSourceLabel
+	} else if !model.LabelName(l.SourceLabel).IsValid() {
+		return fmt.Errorf("%q is invalid source_label", l.SourceLabel)
+	}
+	if len(l.Path) == 0 {
+		l.Path = "/"
+	} else if !strings.HasPrefix(l.Path, "/") {
+		return fmt.Errorf("path must start with \"/\": %q", l.Path)
+	}
+	return nil
+}
+
+func (l *ListenConfig) GetStream(_ context.Context, name, _ string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("datasource %s receives pushed data, it can only be collected in stream mode", name)
+}
+
+func isListenType(t DatasourceType) bool {
+	return t == ListenUDP || t == ListenTCP || t == ListenHTTP
+}
+
+// listenHandler handles the lines received by a listener on behalf of a datasource.
+type listenHandler struct {
+	owner      string
+	datasource string
+	config     *ListenConfig
+	separators []string
+	logger     log.Logger
+	handle     func(source string, line []byte)
+}
+
+// reject records a message or a connection that is refused by the listener.
+func (h *listenHandler) reject(msg string, keyvals ...interface{}) {
+	collectErrorCount.WithLabelValues("datasource", h.datasource).Inc()
+	level.Warn(h.logger).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// listener is a socket bound for a listening datasource. It is shared by the successive configs that contain the
+// datasource, because the config is reloaded by starting the new config before stopping the old one.
+type listener struct {
+	key     string
+	mux     sync.Mutex
+	refs    int
+	handler *listenHandler
+	active  int
+	conns   map[net.Conn]struct{}
+	closer  io.Closer
+}
+
+var (
+	listenersMux sync.Mutex
+	listeners    = map[string]*listener{}
+)
+
+// acquireListener binds the address of the datasource, or takes over the listener already bound to it by the same
+// datasource of a previous config. The returned function releases the listener.
+func acquireListener(ds *Datasource, h *listenHandler) (release func(), err error) {
+	key := string(ds.Type) + "://" + ds.Url
+	listenersMux.Lock()
+	defer listenersMux.Unlock()
+	l, ok := listeners[key]
+	if ok {
+		l.mux.Lock()
+		owner := l.handler.owner
+		if owner != h.owner {
+			l.mux.Unlock()
+			return nil, fmt.Errorf("address %s is already listened by datasource %s", ds.Url, owner)
+		}
+		l.handler = h
+		l.refs++
+		l.mux.Unlock()
+	} else {
+		l = &listener{key: key, handler: h, refs: 1, conns: map[net.Conn]struct{}{}}
+		switch ds.Type {
+		case ListenUDP:
+			err = l.listenUDP(ds.Url)
+		case ListenTCP:
+			err = l.listenTCP(ds.Url)
+		case ListenHTTP:
+			err = l.listenHTTP(ds.Url)
+		default:
+			err = fmt.Errorf("unknown listen datasource type: %s", ds.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		listeners[key] = l
+	}
+	var once sync.Once
+	return func() { once.Do(l.release) }, nil
+}
+
+func (l *listener) release() {
+	listenersMux.Lock()
+	defer listenersMux.Unlock()
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.refs--; l.refs > 0 {
+		return
+	}
+	delete(listeners, l.key)
+	l.closer.Close()
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+func (l *listener) current() *listenHandler {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.handler
+}
+
+// enter counts a new connection or request, it returns false if the limit of the current handler is reached.
+func (l *listener) enter(h *listenHandler) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.active >= h.config.MaxConnections {
+		return false
+	}
+	l.active++
+	return true
+}
+
+func (l *listener) leave() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.active--
+}
+
+// sourceHost returns the host of the address of the sender, the port is dropped so that the source label does not
+// change with every connection.
+func sourceHost(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+func (l *listener) listenUDP(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l.closer = conn
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				level.Warn(l.current().logger).Log("msg", "failed to read datagram", "err", err)
+				continue
+			}
+			h := l.current()
+			if n > h.config.MaxMessageSize {
+				h.reject("datagram is too large", "source", from, "size", n)
+				continue
+			}
+			source := sourceHost(from)
+			for _, line := range splitLines(buf[:n], h.separators) {
+				h.handle(source, line)
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *listener) listenTCP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	l.closer = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				level.Warn(l.current().logger).Log("msg", "failed to accept connection", "err", err)
+				time.Sleep(time.Millisecond * 100)
+				continue
+			}
+			h := l.current()
+			if !l.enter(h) {
+				h.reject("too many connections", "source", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+			l.mux.Lock()
+			if l.refs <= 0 {
+				// the listener has been released while accepting the connection
+				l.mux.Unlock()
+				l.leave()
+				conn.Close()
+				return
+			}
+			l.conns[conn] = struct{}{}
+			l.mux.Unlock()
+			go func() {
+				defer l.leave()
+				defer func() {
+					l.mux.Lock()
+					delete(l.conns, conn)
+					l.mux.Unlock()
+					conn.Close()
+				}()
+				l.serveConn(conn, h)
+			}()
+		}
+	}()
+	return nil
+}
+
+// serveConn handles the lines received over the connection, the connection is closed if a line is longer than the
+// max_message_size.
+func (l *listener) serveConn(conn net.Conn, h *listenHandler) {
+	source := sourceHost(conn.RemoteAddr())
+	sepLen := 0
+	for _, sep := range h.separators {
+		if len(sep) > sepLen {
+			sepLen = len(sep)
+		}
+	}
+	maxSize := h.config.MaxMessageSize + sepLen
+	scanner := bufio.NewScanner(conn)
+	// the max token size is the capacity of the initial buffer if it is larger than maxSize
+	if maxSize < 4096 {
+		scanner.Buffer(make([]byte, 0, maxSize), maxSize)
+	} else {
+		scanner.Buffer(make([]byte, 0, 4096), maxSize)
+	}
+	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if idx, n := indexSeparator(data, h.separators); idx >= 0 {
+			return idx + n, data[:idx], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		l.current().handle(source, scanner.Bytes())
+	}
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		l.current().reject("line is too long, closing the connection", "source", conn.RemoteAddr())
+	} else if err != nil && !errors.Is(err, net.ErrClosed) {
+		level.Debug(l.current().logger).Log("msg", "failed to read connection", "source", conn.RemoteAddr(), "err", err)
+	}
+}
+
+func (l *listener) listenHTTP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		ReadHeaderTimeout: time.Second * 10,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := l.current()
+			if r.URL.Path != h.config.Path {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Method != http.MethodPost && r.Method != http.MethodPut {
+				w.Header().Set("Allow", "POST, PUT")
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			if !l.enter(h) {
+				h.reject("too many concurrent requests", "source", r.RemoteAddr)
+				http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+				return
+			}
+			defer l.leave()
+			body, err := io.ReadAll(io.LimitReader(r.Body, int64(h.config.MaxMessageSize)+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if len(body) > h.config.MaxMessageSize {
+				h.reject("request body is too large", "source", r.RemoteAddr)
+				http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			source := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(source); err == nil {
+				source = host
+			}
+			for _, line := range splitLines(body, h.separators) {
+				h.handle(source, line)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	l.closer = server
+	go server.Serve(ln)
+	return nil
+}
+
+// withSource returns a copy of the listening datasource that sets the address of the sender to the source_label, before
+// the relabel_configs of the collect.
+func (d *Datasource) withSource(source string) *Datasource {
+	ds := *d
+	rc := DefaultRelabelConfig
+	rc.TargetLabel = d.Config.(*ListenConfig).SourceLabel
+	rc.Replacement = strings.ReplaceAll(source, "$", "$$")
+	ds.implicitRelabelConfigs = append(d.implicitRelabelConfigs[:len(d.implicitRelabelConfigs):len(d.implicitRelabelConfigs)], &rc)
+	return &ds
+}
+
+// listen receives the data pushed to the listening datasource until the context is done, each line is handled like
+// the lines read from a stream.
+func (c *CollectConfig) listen(ctx context.Context, ds *Datasource) error {
+	config := ds.Config.(*ListenConfig)
+	logger := log.With(c.logger, "datasource", ds.Name)
+	h := &listenHandler{
+		owner:      c.Name + "/" + ds.Name,
+		datasource: ds.Name,
+		config:     config,
+		separators: ds.LineSeparator,
+		logger:     logger,
+		handle: func(source string, line []byte) {
+			rcs := c.relabelConfigs(ds.withSource(source))
+			datapoints, samples, err := c.handleData(logger, line, rcs, 0)
+			if err != nil {
+				level.Info(logger).Log("msg", "failed to parse metric", "err", err)
+			}
+			observeData(c.Name, ds.Name, len(line), 1, datapoints, samples)
+			datasourceLastSuccess.WithLabelValues(c.Name, ds.Name).Set(float64(time.Now().UnixNano()) / 1e9)
+		},
+	}
+	release, err := acquireListener(ds, h)
+	if err != nil {
+		datasourceSuccess.WithLabelValues(c.Name, ds.Name).Set(0)
+		return fmt.Errorf("failed to listen on %s: %s", ds.Url, err)
+	}
+	datasourceSuccess.WithLabelValues(c.Name, ds.Name).Set(1)
+	go func() {
+		<-ctx.Done()
+		release()
+	}()
+	return nil
+}
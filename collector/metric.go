@@ -15,6 +15,7 @@ package collector
 
 import (
 	"bytes"
+	"container/list"
 	"encoding/json"
 	"fmt"
 	"github.com/MicroOps-cn/data_exporter/pkg/values"
@@ -41,6 +42,7 @@ const (
 	Gauge     MetricType = "gauge"
 	Counter   MetricType = "counter"
 	Histogram MetricType = "histogram"
+	Summary   MetricType = "summary"
 )
 
 const (
@@ -57,6 +59,15 @@ const (
 	LabelMetricValuesIndex          = "__values_index__"
 	LabelMetricValuesIndexSeparator = "__values_index_separator__"
 	LabelMetricValuesIndexLabelName = "__values_index_label_name__"
+	LabelMetricType                 = "__type__"
+	LabelMetricBucketCounts         = "__bucket_counts__"
+	LabelMetricQuantiles            = "__quantiles__"
+	LabelMetricQuantileValues       = "__quantile_values__"
+	LabelMetricCount                = "__count__"
+	LabelMetricSum                  = "__sum__"
+	LabelMetricObjectives           = "__objectives__"
+	LabelMetricMaxAge               = "__max_age__"
+	LabelMetricAgeBuckets           = "__age_buckets__"
 )
 
 func (d MetricType) ToLower() MetricType {
@@ -117,7 +128,15 @@ type MetricConfig struct {
 	RelabelConfigs RelabelConfigs `yaml:"relabel_configs,omitempty" json:"relabel_configs"`
 	Match          MetricMatch    `yaml:"match"`
 	MetricType     MetricType     `yaml:"metric_type" json:"metric_type"`
-	logger         log.Logger
+	// TTL is the time after which a series that is not updated by stream collect is deleted, 0 means never.
+	TTL time.Duration `yaml:"ttl,omitempty" json:"ttl"`
+	// MaxSeries is the maximum number of series kept by stream collect, the least recently updated series
+	// is deleted when the limit is exceeded, 0 means unlimited.
+	MaxSeries int `yaml:"max_series,omitempty" json:"max_series"`
+	// DuplicatePolicy is how the series with the same name and labels are merged, defaults to error.
+	DuplicatePolicy DuplicatePolicy `yaml:"duplicate_policy,omitempty" json:"duplicate_policy"`
+	logger          log.Logger
+	collect         string
 }
 
 func (mc *MetricConfig) UnmarshalJSON(raw []byte) error {
@@ -139,7 +158,13 @@ func (mc *MetricConfig) UnmarshalYAML(value *yaml.Node) error {
 	if mc.MetricType == "" {
 		mc.MetricType = Gauge
 	}
-	return nil
+	if mc.TTL < 0 {
+		return fmt.Errorf("ttl cannot be negative: %s", mc.TTL)
+	}
+	if mc.MaxSeries < 0 {
+		return fmt.Errorf("max_series cannot be negative: %d", mc.MaxSeries)
+	}
+	return mc.DuplicatePolicy.verify(mc.MetricType)
 }
 
 func (mc *MetricConfig) Relabels(logger log.Logger, rcs RelabelConfigs, lvs Labels) (newLvs Labels, err error) {
@@ -151,6 +176,9 @@ func (mc *MetricConfig) Relabels(logger log.Logger, rcs RelabelConfigs, lvs Labe
 	if newLvs, err = rcs.Process(lvs); err != nil {
 		level.Error(logger).Log("msg", "failed to relabel", "err", err, "labels", lvs, "relabelConfigs", rcs)
 		return nil, err
+	} else if newLvs == nil {
+		// dropped by relabel_configs
+		return nil, nil
 	}
 	if !newLvs.Has(LabelMetricName) {
 		metricName := strings.ToLower(strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(mc.Name))
@@ -547,6 +575,29 @@ func (m *MetricGenerator) getOpts() (prometheus.Opts, error) {
 	return opts, nil
 }
 
+// prometheusMetrics returns the metrics of the generator, the nil metrics and errors are omitted.
+func (m *MetricGenerator) prometheusMetrics() (metrics []prometheus.Metric, errs []error) {
+	if !m.Labels.Has(LabelMetricValues) {
+		if metric, err := m.getMetric(); err != nil {
+			return nil, []error{err}
+		} else {
+			return []prometheus.Metric{metric}, nil
+		}
+	}
+	ms, es := m.GetMetrics()
+	for _, metric := range ms {
+		if metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	for _, err := range es {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return metrics, errs
+}
+
 func (m *MetricGenerator) GetMetrics() ([]prometheus.Metric, []error) {
 	opts, err := m.getOpts()
 	if err != nil {
@@ -580,8 +631,145 @@ func (m *MetricGenerator) GetMetrics() ([]prometheus.Metric, []error) {
 	return metrics, errs
 }
 
+// getMetricType returns the metric type, the __type__ label takes precedence over the configured type.
+func (m *MetricGenerator) getMetricType() MetricType {
+	if metricType := m.Labels.Get(LabelMetricType); len(metricType) > 0 {
+		return MetricType(metricType).ToLower()
+	}
+	return m.MetricType.ToLower()
+}
+
+// isAggregated returns true if the histogram or summary has been aggregated by the data source,
+// such as the ones parsed from the Prometheus exposition format.
+func (m *MetricGenerator) isAggregated() bool {
+	return m.Labels.Has(LabelMetricCount)
+}
+
+func (m *MetricGenerator) getCountAndSum() (count uint64, sum float64, err error) {
+	if count, err = strconv.ParseUint(strings.TrimSpace(m.Labels.Get(LabelMetricCount)), 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("count format error: %s", err)
+	}
+	if sum, err = values.NewValues(m.Labels.Get(LabelMetricSum), "0").Float64(); err != nil {
+		return 0, 0, fmt.Errorf("sum format error: %s", err)
+	}
+	return count, sum, nil
+}
+
+func (m *MetricGenerator) getPairs(keyLabel, valueLabel string) (map[float64]float64, error) {
+	keys, err := values.NewValues(m.Labels.Get(keyLabel), "").Float64s()
+	if err != nil {
+		return nil, fmt.Errorf("%s format error: %s", keyLabel, err)
+	}
+	vals, err := values.NewValues(m.Labels.Get(valueLabel), "").Float64s()
+	if err != nil {
+		return nil, fmt.Errorf("%s format error: %s", valueLabel, err)
+	}
+	if len(keys) != len(vals) {
+		return nil, fmt.Errorf("%s length not equal to %s length", keyLabel, valueLabel)
+	}
+	pairs := make(map[float64]float64, len(keys))
+	for i := range keys {
+		pairs[keys[i]] = vals[i]
+	}
+	return pairs, nil
+}
+
+func (m *MetricGenerator) getAggregatedMetric(opts prometheus.Opts, lvs Labels) (prometheus.Metric, error) {
+	count, sum, err := m.getCountAndSum()
+	if err != nil {
+		return nil, err
+	}
+	desc := prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, lvs.Keys(), opts.ConstLabels)
+	switch m.getMetricType() {
+	case Histogram:
+		pairs, err := m.getPairs(LabelMetricBuckets, LabelMetricBucketCounts)
+		if err != nil {
+			return nil, err
+		}
+		buckets := make(map[float64]uint64, len(pairs))
+		for bound, bucketCount := range pairs {
+			if !math.IsInf(bound, +1) {
+				buckets[bound] = uint64(bucketCount)
+			}
+		}
+		return prometheus.NewConstHistogram(desc, count, sum, buckets, lvs.Values()...)
+	case Summary:
+		quantiles, err := m.getPairs(LabelMetricQuantiles, LabelMetricQuantileValues)
+		if err != nil {
+			return nil, err
+		}
+		return prometheus.NewConstSummary(desc, count, sum, quantiles, lvs.Values()...)
+	default:
+		return nil, fmt.Errorf("metric type %s cannot be aggregated", m.getMetricType())
+	}
+}
+
+// getSummaryOpts builds the summary options from the special labels:
+//   - __objectives__: quantile and absolute error pairs, e.g. "0.5:0.05,0.9:0.01,0.99:0.001"
+//   - __max_age__: duration for which an observation stays relevant, e.g. "10m"
+//   - __age_buckets__: number of buckets used to exclude observations that are older than max age
+func (m *MetricGenerator) getSummaryOpts(opts prometheus.Opts) (prometheus.SummaryOpts, error) {
+	summaryOpts := prometheus.SummaryOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        opts.Name,
+		Help:        opts.Help,
+		ConstLabels: opts.ConstLabels,
+	}
+	for _, objective := range values.NewValues(m.Labels.Get(LabelMetricObjectives), "").Split() {
+		pair := objective.Split(':')
+		if len(pair) != 2 {
+			return summaryOpts, fmt.Errorf("objective format error: %q, expected <quantile>:<error>", objective)
+		}
+		quantile, err := pair[0].Float64()
+		if err != nil {
+			return summaryOpts, fmt.Errorf("objective format error: %s", err)
+		}
+		absErr, err := pair[1].Float64()
+		if err != nil {
+			return summaryOpts, fmt.Errorf("objective format error: %s", err)
+		}
+		if quantile < 0 || quantile > 1 {
+			return summaryOpts, fmt.Errorf("objective format error: quantile %v is not in [0,1]", quantile)
+		}
+		if summaryOpts.Objectives == nil {
+			summaryOpts.Objectives = make(map[float64]float64)
+		}
+		summaryOpts.Objectives[quantile] = absErr
+	}
+	if maxAge := m.Labels.Get(LabelMetricMaxAge); len(maxAge) > 0 {
+		var err error
+		if summaryOpts.MaxAge, err = values.NewValues(maxAge, "").Duration(); err != nil {
+			return summaryOpts, fmt.Errorf("max age format error: %s", err)
+		} else if summaryOpts.MaxAge <= 0 {
+			return summaryOpts, fmt.Errorf("max age must be positive: %s", maxAge)
+		}
+	}
+	if ageBuckets := m.Labels.Get(LabelMetricAgeBuckets); len(ageBuckets) > 0 {
+		buckets, err := values.NewValues(ageBuckets, "").Int()
+		if err != nil {
+			return summaryOpts, fmt.Errorf("age buckets format error: %s", err)
+		} else if buckets <= 0 {
+			return summaryOpts, fmt.Errorf("age buckets must be positive: %s", ageBuckets)
+		}
+		summaryOpts.AgeBuckets = uint32(buckets)
+	}
+	return summaryOpts, nil
+}
+
 func (m *MetricGenerator) getMetricFromLvs(opts prometheus.Opts, lvs Labels, t time.Time, value float64) (prometheus.Metric, error) {
-	switch m.MetricType.ToLower() {
+	metricType := m.getMetricType()
+	if (metricType == Histogram || metricType == Summary) && m.isAggregated() {
+		metric, err := m.getAggregatedMetric(opts, lvs)
+		if err != nil {
+			return nil, err
+		}
+		if !t.IsZero() {
+			return prometheus.NewMetricWithTimestamp(t, metric), nil
+		}
+		return metric, nil
+	}
+	switch metricType {
 	case Gauge:
 		metric := prometheus.NewGaugeVec(prometheus.GaugeOpts(opts), lvs.Keys()).With(lvs.Map())
 		metric.Set(value)
@@ -618,12 +806,27 @@ func (m *MetricGenerator) getMetricFromLvs(opts prometheus.Opts, lvs Labels, t t
 			return nil, fmt.Errorf("failed to get metric from histogram: %s", m.MetricType)
 		}
 
+		if !t.IsZero() {
+			return prometheus.NewMetricWithTimestamp(t, metric), nil
+		}
+		return metric, nil
+	case Summary:
+		summaryOpts, err := m.getSummaryOpts(opts)
+		if err != nil {
+			return nil, err
+		}
+		summary := prometheus.NewSummaryVec(summaryOpts, lvs.Keys())
+		summary.With(lvs.Map()).Observe(value)
+		metric, err := summary.MetricVec.GetMetricWith(lvs.Map())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metric from summary: %s", m.MetricType)
+		}
 		if !t.IsZero() {
 			return prometheus.NewMetricWithTimestamp(t, metric), nil
 		}
 		return metric, nil
 	default:
-		return nil, fmt.Errorf("unknown metric type: %s", m.MetricType)
+		return nil, fmt.Errorf("unknown metric type: %s", metricType)
 	}
 }
 
@@ -668,25 +871,130 @@ func (m *MetricGenerator) getTime() time.Time {
 	return time.Time{}
 }
 
+type metricSeries struct {
+	hash     uint64
+	labels   prometheus.Labels
+	lastSeen time.Time
+	// ttl overrides the ttl of the metric, it is set for the series that have been pushed.
+	ttl time.Duration
+	// elem is the element of the series in metricChildren.lru.
+	elem *list.Element
+}
+
+// metricChildren tracks the children (label value combinations) of a metric vector
+// created by stream collect, so that idle children can be deleted.
+type metricChildren struct {
+	ttl       time.Duration
+	maxSeries int
+	series    map[uint64]*metricSeries
+	// lru orders the series from the most recently seen (front) to the least recently seen (back).
+	lru *list.List
+}
+
 type MetricGroup struct {
-	metrics map[string]prometheus.Collector
-	mux     sync.Mutex
+	name     string
+	metrics  map[string]prometheus.Collector
+	children map[string]*metricChildren
+	mux      sync.Mutex
+}
+
+type deletableCollector interface {
+	prometheus.Collector
+	Delete(prometheus.Labels) bool
+}
+
+// touch records that the series has been seen, ttl overrides the ttl of the metric if it is greater than 0. If the
+// number of series exceeds max_series, the least recently seen series are deleted.
+func (mg *MetricGroup) touch(metricHash string, mc *MetricConfig, labels map[string]string, ttl time.Duration) {
+	children, ok := mg.children[metricHash]
+	if !ok {
+		children = &metricChildren{series: map[uint64]*metricSeries{}, lru: list.New()}
+		if mc != nil {
+			children.ttl, children.maxSeries = mc.TTL, mc.MaxSeries
+		}
+		if mg.children == nil {
+			mg.children = make(map[string]*metricChildren)
+		}
+		mg.children[metricHash] = children
+	}
+	hash := FromMap(labels).Hash()
+	if series, ok := children.series[hash]; ok {
+		series.lastSeen, series.ttl = time.Now(), ttl
+		children.lru.MoveToFront(series.elem)
+		return
+	}
+	series := &metricSeries{hash: hash, labels: labels, lastSeen: time.Now(), ttl: ttl}
+	series.elem = children.lru.PushFront(series)
+	children.series[hash] = series
+	streamSeries.WithLabelValues(mg.name).Inc()
+	for children.maxSeries > 0 && len(children.series) > children.maxSeries {
+		mg.deleteSeries(metricHash, children.lru.Back().Value.(*metricSeries).hash, "max_series")
+	}
+}
+
+// must be called with mg.mux held.
+func (mg *MetricGroup) deleteSeries(metricHash string, hash uint64, reason string) {
+	children := mg.children[metricHash]
+	series, ok := children.series[hash]
+	if !ok {
+		return
+	}
+	delete(children.series, hash)
+	children.lru.Remove(series.elem)
+	if vec, ok := mg.metrics[metricHash].(deletableCollector); ok {
+		vec.Delete(series.labels)
+	}
+	streamSeries.WithLabelValues(mg.name).Dec()
+	streamSeriesEvicted.WithLabelValues(mg.name, reason).Inc()
+}
+
+// expire deletes the series that have not been seen within the ttl of their metric.
+func (mg *MetricGroup) expire() {
+	mg.mux.Lock()
+	defer mg.mux.Unlock()
+	mg.expireLocked()
+}
+
+func (mg *MetricGroup) expireLocked() {
+	now := time.Now()
+	for metricHash, children := range mg.children {
+		for hash, series := range children.series {
+			ttl := children.ttl
+			if series.ttl > 0 {
+				ttl = series.ttl
+			}
+			if ttl > 0 && now.Sub(series.lastSeen) > ttl {
+				mg.deleteSeries(metricHash, hash, "ttl")
+			}
+		}
+	}
 }
 
 func (mg *MetricGroup) handle(mgr MetricGenerator) error {
+	return mg.handleWithTTL(mgr, 0)
+}
+
+// handleWithTTL is handle, the series is deleted if it is not updated within the ttl instead of the ttl of the metric.
+func (mg *MetricGroup) handleWithTTL(mgr MetricGenerator, ttl time.Duration) error {
+	mg.mux.Lock()
+	defer mg.mux.Unlock()
 	opts, err := mgr.getOpts()
 	if err != nil {
 		return err
 	}
+	metricType := mgr.getMetricType()
+	if mgr.isAggregated() {
+		return fmt.Errorf("aggregated %s is not supported by stream collect", metricType)
+	}
 	fqName := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
 	labelKeys := mgr.Labels.WithoutEmpty().WithoutLabels().Keys()
 	sort.Strings(labelKeys)
-	metricHash := string(mgr.MetricType.ToLower()) + "\x00" + fqName + "\x00" + strings.Join(labelKeys, "\x00")
+	metricHash := string(metricType) + "\x00" + fqName + "\x00" + strings.Join(labelKeys, "\x00")
 
 	labels := mgr.Labels.WithoutEmpty().WithoutLabels().Map()
 	promMetric, ok := mg.metrics[metricHash]
 	if !ok || promMetric == nil {
-		switch mgr.MetricType.ToLower() {
+		switch metricType {
 		case Gauge:
 			promMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts), labelKeys)
 			mg.createMetric(metricHash, promMetric)
@@ -700,7 +1008,7 @@ func (mg *MetricGroup) handle(mgr MetricGenerator) error {
 			} else if len(buckets) == 0 {
 				return fmt.Errorf("bucket length == 0")
 			}
-			promMetric := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			promMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 				Namespace:   opts.Namespace,
 				Subsystem:   opts.Subsystem,
 				Name:        opts.Name,
@@ -709,11 +1017,18 @@ func (mg *MetricGroup) handle(mgr MetricGenerator) error {
 				Buckets:     append(buckets, math.Inf(+1)),
 			}, labelKeys)
 			mg.createMetric(metricHash, promMetric)
+		case Summary:
+			summaryOpts, err := mgr.getSummaryOpts(opts)
+			if err != nil {
+				return err
+			}
+			promMetric = prometheus.NewSummaryVec(summaryOpts, labelKeys)
+			mg.createMetric(metricHash, promMetric)
 		default:
-			return fmt.Errorf("unknown metric type: %s", mgr.MetricType)
+			return fmt.Errorf("unknown metric type: %s", metricType)
 		}
 	}
-	switch mgr.MetricType.ToLower() {
+	switch metricType {
 	case Gauge:
 		if counterVec, ok := promMetric.(*prometheus.GaugeVec); ok {
 			if value, err := mgr.getValue(); err != nil && err != ErrValueIsNull {
@@ -738,21 +1053,30 @@ func (mg *MetricGroup) handle(mgr MetricGenerator) error {
 				histogramVec.With(labels).Observe(value)
 			}
 		}
+	case Summary:
+		if summaryVec, ok := promMetric.(*prometheus.SummaryVec); ok {
+			if value, err := mgr.getValue(); err != nil {
+				return err
+			} else {
+				summaryVec.With(labels).Observe(value)
+			}
+		}
 	default:
-		return fmt.Errorf("unknown metric type: %s", mgr.MetricType)
+		return fmt.Errorf("unknown metric type: %s", metricType)
 	}
+	mg.touch(metricHash, mgr.Datapoint, labels, ttl)
 	return nil
 }
 
+// must be called with mg.mux held.
 func (mg *MetricGroup) createMetric(metricHash string, collector prometheus.Collector) {
-	mg.mux.Lock()
-	defer mg.mux.Unlock()
 	mg.metrics[metricHash] = collector
 }
 
 func (mg *MetricGroup) Collect(metrics chan<- prometheus.Metric) {
 	mg.mux.Lock()
 	defer mg.mux.Unlock()
+	mg.expireLocked()
 	for _, metric := range mg.metrics {
 		metric.Collect(metrics)
 	}
@@ -14,14 +14,22 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/MicroOps-cn/data_exporter/pkg/wrapper"
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var xmlContent = `
@@ -386,3 +394,625 @@ func TestDatapoint_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+const csvContent = `# generated by report tool
+report date: 2022-03-17
+host;"disk; mount";used;size
+server1;"/data";10;100
+server2;"/""quoted""";20;200
+`
+
+func TestCollectConfig_GetMetricByCsv(t *testing.T) {
+	var c CollectConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+name: csv
+data_format: csv
+csv:
+  delimiter: ";"
+  comment: "#"
+  skip_rows: 1
+  header: true
+datasource:
+  - type: file
+    url: ./report.csv
+metrics:
+  - name: disk_used
+    match:
+      labels:
+        __value__: used
+        mount: disk; mount
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	metrics := make(chan MetricGenerator, 10)
+	c.GetMetric(log.NewNopLogger(), []byte(csvContent), nil, metrics)
+	close(metrics)
+	var results []map[string]string
+	for metric := range metrics {
+		results = append(results, metric.Labels.Map())
+	}
+	require.Len(t, results, 2)
+	require.Equal(t, "server1", results[0]["host"])
+	require.Equal(t, "/data", results[0]["mount"])
+	require.Equal(t, "10", results[0][LabelMetricValue])
+	require.NotContains(t, results[0], "disk; mount")
+	require.Equal(t, `/"quoted"`, results[1]["mount"])
+	require.Equal(t, "20", results[1][LabelMetricValue])
+}
+
+func TestCollectConfig_CsvLineMode(t *testing.T) {
+	var c CollectConfig
+	require.Error(t, yaml.Unmarshal([]byte(`
+data_format: tsv
+datasource:
+  - {type: file, url: ./report.tsv, read_mode: line}
+`), &c))
+	for _, option := range []string{"header: true", "skip_rows: 1"} {
+		var c CollectConfig
+		require.Error(t, yaml.Unmarshal([]byte(`
+data_format: tsv
+csv:
+  columns: [host, value]
+  `+option+`
+datasource:
+  - {type: file, url: ./report.tsv, read_mode: stream}
+`), &c))
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+data_format: tsv
+csv:
+  columns: [host, value]
+datasource:
+  - {type: file, url: ./report.tsv, read_mode: line}
+metrics:
+  - name: load
+    match:
+      labels:
+        __value__: value
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	metrics := make(chan MetricGenerator, 10)
+	c.GetMetric(log.NewNopLogger(), []byte("host\tvalue"), nil, metrics)
+	c.GetMetric(log.NewNopLogger(), []byte("server1\t0.5"), nil, metrics)
+	close(metrics)
+	var results []map[string]string
+	for metric := range metrics {
+		results = append(results, metric.Labels.Map())
+	}
+	require.Len(t, results, 1)
+	require.Equal(t, "server1", results[0]["host"])
+	require.Equal(t, "0.5", results[0][LabelMetricValue])
+}
+
+const promContent = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+# A histogram, which has a pretty complex representation in the text format:
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="0.1"} 33444
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+# HELP rpc_duration_seconds A summary of the RPC duration in seconds.
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds{quantile="0.99"} 76656
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+metric_without_timestamp_and_labels 12.47
+`
+
+func TestCollectConfig_GetMetricByPrometheus(t *testing.T) {
+	var c CollectConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+name: proxy
+data_format: prometheus
+datasource:
+  - type: file
+    url: ./metrics.txt
+metrics:
+  - name: all
+    relabel_configs:
+      - source_labels: [__name__]
+        regex: "http_requests_total"
+        target_label: __name__
+        replacement: "proxy_http_requests_total"
+      - source_labels: [code]
+        regex: "400"
+        action: drop
+`), &c))
+	c.SetLogger(log.NewNopLogger())
+	mgs := NewMetricGenerators(10, log.NewNopLogger())
+	go func() {
+		c.GetMetric(log.NewNopLogger(), []byte(promContent), nil, mgs.Ch())
+		close(mgs.Ch())
+	}()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(mgs)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	result := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		result[family.GetName()] = family
+	}
+	require.Len(t, result, 4)
+	require.Equal(t, dto.MetricType_COUNTER, result["proxy_http_requests_total"].GetType())
+	require.Len(t, result["proxy_http_requests_total"].GetMetric(), 1)
+	require.Equal(t, float64(1027), result["proxy_http_requests_total"].GetMetric()[0].GetCounter().GetValue())
+	require.Equal(t, int64(1395066363000), result["proxy_http_requests_total"].GetMetric()[0].GetTimestampMs())
+	require.Equal(t, "The total number of HTTP requests.", result["proxy_http_requests_total"].GetHelp())
+
+	histogram := result["http_request_duration_seconds"]
+	require.Equal(t, dto.MetricType_HISTOGRAM, histogram.GetType())
+	require.Equal(t, uint64(144320), histogram.GetMetric()[0].GetHistogram().GetSampleCount())
+	require.Len(t, histogram.GetMetric()[0].GetHistogram().GetBucket(), 2)
+	require.Equal(t, uint64(33444), histogram.GetMetric()[0].GetHistogram().GetBucket()[1].GetCumulativeCount())
+
+	summary := result["rpc_duration_seconds"]
+	require.Equal(t, dto.MetricType_SUMMARY, summary.GetType())
+	require.Equal(t, 1.7560473e+07, summary.GetMetric()[0].GetSummary().GetSampleSum())
+	require.Len(t, summary.GetMetric()[0].GetSummary().GetQuantile(), 2)
+
+	require.Equal(t, dto.MetricType_GAUGE, result["metric_without_timestamp_and_labels"].GetType())
+	require.Empty(t, result["metric_without_timestamp_and_labels"].GetMetric()[0].GetLabel())
+}
+
+func TestMetricGenerator_Summary(t *testing.T) {
+	newGenerator := func(value string) MetricGenerator {
+		m := NewMetricGenerator(log.NewNopLogger(), "latency", Summary)
+		m.Labels.Append(LabelMetricName, "request_duration_seconds")
+		m.Labels.Append(LabelMetricValue, value)
+		m.Labels.Append(LabelMetricObjectives, "0.5:0.05,0.9:0.01")
+		m.Labels.Append(LabelMetricMaxAge, "5m")
+		m.Labels.Append(LabelMetricAgeBuckets, "3")
+		m.Labels.Append("path", "/index.html")
+		return *m
+	}
+	m := newGenerator("0.25")
+	metric, err := m.getMetric()
+	require.NoError(t, err)
+	dtoMetric := dto.Metric{}
+	require.NoError(t, metric.Write(&dtoMetric))
+	require.Equal(t, uint64(1), dtoMetric.GetSummary().GetSampleCount())
+	require.Len(t, dtoMetric.GetSummary().GetQuantile(), 2)
+
+	mg := MetricGroup{metrics: map[string]prometheus.Collector{}}
+	for _, value := range []string{"1", "2", "3"} {
+		require.NoError(t, mg.handle(newGenerator(value)))
+	}
+	ch := make(chan prometheus.Metric, 10)
+	mg.Collect(ch)
+	close(ch)
+	require.Len(t, ch, 1)
+	dtoMetric = dto.Metric{}
+	require.NoError(t, (<-ch).Write(&dtoMetric))
+	require.Equal(t, uint64(3), dtoMetric.GetSummary().GetSampleCount())
+	require.Equal(t, float64(6), dtoMetric.GetSummary().GetSampleSum())
+	require.Equal(t, float64(2), dtoMetric.GetSummary().GetQuantile()[0].GetValue())
+
+	m = newGenerator("1")
+	m.Labels.Append(LabelMetricObjectives, "0.5")
+	_, err = m.getMetric()
+	require.Error(t, err)
+}
+
+func TestMetricGroup_Expire(t *testing.T) {
+	mc := &MetricConfig{Name: "requests", TTL: time.Minute, MaxSeries: 2}
+	newGenerator := func(path string) MetricGenerator {
+		m := NewMetricGenerator(log.NewNopLogger(), "requests", Counter)
+		m.Datapoint = mc
+		m.Labels.Append(LabelMetricName, "http_requests_total")
+		m.Labels.Append(LabelMetricValue, "1")
+		m.Labels.Append("path", path)
+		return *m
+	}
+	collect := func(mg *MetricGroup) int {
+		ch := make(chan prometheus.Metric, 10)
+		mg.Collect(ch)
+		close(ch)
+		return len(ch)
+	}
+	mg := MetricGroup{name: "test_expire", metrics: map[string]prometheus.Collector{}}
+	require.NoError(t, mg.handle(newGenerator("/a")))
+	require.NoError(t, mg.handle(newGenerator("/b")))
+	require.Equal(t, 2, collect(&mg))
+	require.Equal(t, float64(2), testutil.ToFloat64(streamSeries.WithLabelValues("test_expire")))
+
+	// "/a" is seen again, so "/b" is the least recently seen series, it is evicted when "/c" exceeds max_series.
+	require.NoError(t, mg.handle(newGenerator("/a")))
+	require.NoError(t, mg.handle(newGenerator("/c")))
+	require.Equal(t, 2, collect(&mg))
+	require.Equal(t, float64(1), testutil.ToFloat64(streamSeriesEvicted.WithLabelValues("test_expire", "max_series")))
+	var paths []string
+	for _, children := range mg.children {
+		for e := children.lru.Front(); e != nil; e = e.Next() {
+			paths = append(paths, e.Value.(*metricSeries).labels["path"])
+		}
+	}
+	require.Equal(t, []string{"/c", "/a"}, paths)
+
+	for _, children := range mg.children {
+		for _, series := range children.series {
+			if series.labels["path"] == "/a" {
+				series.lastSeen = time.Now().Add(-time.Hour)
+			}
+		}
+	}
+	require.Equal(t, 1, collect(&mg))
+	require.Equal(t, float64(1), testutil.ToFloat64(streamSeriesEvicted.WithLabelValues("test_expire", "ttl")))
+	require.Equal(t, float64(1), testutil.ToFloat64(streamSeries.WithLabelValues("test_expire")))
+}
+
+func TestCollectContext_Interval(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(dataPath, []byte("requests_total 1\n"), 0644))
+	var c CollectConfig
+	require.NoError(t, yaml.Unmarshal([]byte(fmt.Sprintf(`
+name: cached
+data_format: prometheus
+interval: 1h
+datasource:
+  - type: file
+    name: prom
+    url: %q
+metrics:
+  - name: all
+`, dataPath)), &c))
+	c.SetLogger(log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.StartScheduledCollect(ctx)
+
+	gather := func() map[string]*dto.MetricFamily {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(&CollectContext{CollectConfig: &c, Context: context.Background()})
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		result := map[string]*dto.MetricFamily{}
+		for _, family := range families {
+			result[family.GetName()] = family
+		}
+		return result
+	}
+	families := gather()
+	require.Equal(t, float64(1), families["requests_total"].GetMetric()[0].GetGauge().GetValue())
+	require.NotNil(t, families["requests_total"].GetMetric()[0].TimestampMs)
+	require.Contains(t, families, "data_exporter_datasource_cache_age_seconds")
+
+	// scrapes within the interval are served from the cache
+	require.NoError(t, os.WriteFile(dataPath, []byte("requests_total 2\n"), 0644))
+	families = gather()
+	require.Equal(t, float64(1), families["requests_total"].GetMetric()[0].GetGauge().GetValue())
+
+	c.refreshCache(ctx, c.Datasource[0])
+	families = gather()
+	require.Equal(t, float64(2), families["requests_total"].GetMetric()[0].GetGauge().GetValue())
+
+	// the previous metrics are kept if the collection fails
+	require.NoError(t, os.Remove(dataPath))
+	c.refreshCache(ctx, c.Datasource[0])
+	families = gather()
+	require.Equal(t, float64(2), families["requests_total"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestCollectContext_Discovery(t *testing.T) {
+	dir := t.TempDir()
+	for _, target := range []string{"a", "b", "c"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, target), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, target, "metrics.prom"), []byte("requests_total 1\n"), 0644))
+	}
+	sdPath := filepath.Join(dir, "targets.json")
+	require.NoError(t, os.WriteFile(sdPath, []byte(fmt.Sprintf(`[{"targets":[%q],"labels":{"env":"prod"}}]`, filepath.Join(dir, "b"))), 0644))
+	var c CollectConfig
+	require.NoError(t, yaml.Unmarshal([]byte(fmt.Sprintf(`
+name: discovery
+data_format: prometheus
+target_parallelism: 1
+static_configs:
+  - targets: [%q]
+    labels:
+      env: dev
+file_sd_configs:
+  - files: [%q]
+datasource:
+  - type: file
+    name: prom
+    url: "{{ .__address__ }}/metrics.prom"
+    allow_replace: true
+relabel_configs:
+  # the target labels are set before the relabel_configs of the collect
+  - source_labels: [env]
+    target_label: stage
+metrics:
+  - name: all
+`, filepath.Join(dir, "a"), filepath.Join(dir, "*.json"))), &c))
+	c.SetLogger(log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.StartDiscovery(ctx)
+
+	gather := func() map[string]string {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(&CollectContext{CollectConfig: &c, Context: context.Background()})
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		result := map[string]string{}
+		for _, family := range families {
+			if family.GetName() != "requests_total" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, pair := range m.GetLabel() {
+					labels[pair.GetName()] = pair.GetValue()
+				}
+				result[filepath.Base(labels["instance"])] = labels["stage"]
+			}
+		}
+		return result
+	}
+	require.Eventually(t, func() bool {
+		return len(gather()) == 2
+	}, time.Second*5, time.Millisecond*50)
+	require.Equal(t, map[string]string{"a": "dev", "b": "prod"}, gather())
+
+	// changes of the file_sd files are watched
+	require.NoError(t, os.WriteFile(sdPath, []byte(fmt.Sprintf(`[{"targets":[%q],"labels":{"env":"test"}}]`, filepath.Join(dir, "c"))), 0644))
+	require.Eventually(t, func() bool {
+		return gather()["c"] == "test"
+	}, time.Second*5, time.Millisecond*50)
+	require.Equal(t, map[string]string{"a": "dev", "c": "test"}, gather())
+
+	// the previous targets are kept if the file is invalid
+	require.NoError(t, os.WriteFile(sdPath, []byte(`[{"targets":`), 0644))
+	time.Sleep(time.Millisecond * 200)
+	require.Equal(t, map[string]string{"a": "dev", "c": "test"}, gather())
+}
+
+type dataCollector struct {
+	collect *CollectConfig
+	data    []byte
+}
+
+func (d *dataCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (d *dataCollector) Collect(ch chan<- prometheus.Metric) {
+	d.collect.CollectData(map[*Datasource][]byte{d.collect.Datasource[0]: d.data}, ch)
+}
+
+func TestGenerateConfig(t *testing.T) {
+	for _, tc := range []struct {
+		format   DataFormat
+		data     string
+		expected map[string]string
+	}{{
+		format: Json,
+		data:   `{"code":0,"servers":[{"name":"a","host-name":"h1","load":1.5,"disks":[{"dev":"sda","used.bytes":"10"}]},{"name":"b","host-name":"h2","load":2,"disks":[]}]}`,
+		expected: map[string]string{
+			`demo_code{}`: "0",
+			`demo_servers_load{host_name="h1",name="a"}`: "1.5",
+			`demo_servers_load{host_name="h2",name="b"}`: "2",
+			`demo_servers_disks_used_bytes{dev="sda"}`:   "10",
+		},
+	}, {
+		format: Yaml,
+		data:   "hosts:\n  - name: a\n    up: 1\n",
+		expected: map[string]string{
+			`demo_hosts_up{name="a"}`: "1",
+		},
+	}, {
+		format: Xml,
+		data:   `<root><city name="a" tem="20">15<wind speed="3"/></city><city name="b" tem="21">16</city></root>`,
+		expected: map[string]string{
+			`demo_root_city{name="a"}`:     "15",
+			`demo_root_city{name="b"}`:     "16",
+			`demo_root_city_tem{name="a"}`: "20",
+			`demo_root_city_tem{name="b"}`: "21",
+			`demo_root_city_wind_speed{}`:  "3",
+		},
+	}} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			generated, err := GenerateConfig("demo", tc.format, "sample", []byte(tc.data))
+			require.NoError(t, err)
+			var config struct {
+				Collects Collects `yaml:"collects"`
+			}
+			require.NoError(t, yaml.Unmarshal(generated, &config), string(generated))
+			config.Collects.SetLogger(log.NewNopLogger())
+			reg := prometheus.NewPedanticRegistry()
+			reg.MustRegister(&dataCollector{collect: &config.Collects[0], data: []byte(tc.data)})
+			families, err := reg.Gather()
+			require.NoError(t, err, string(generated))
+			result := map[string]string{}
+			for _, family := range families {
+				for _, m := range family.GetMetric() {
+					var labels []string
+					for _, pair := range m.GetLabel() {
+						labels = append(labels, fmt.Sprintf("%s=%q", pair.GetName(), pair.GetValue()))
+					}
+					result[fmt.Sprintf("%s{%s}", family.GetName(), strings.Join(labels, ","))] = fmt.Sprint(m.GetGauge().GetValue())
+				}
+			}
+			require.Equal(t, tc.expected, result, string(generated))
+		})
+	}
+}
+
+func TestRelabelConfigs_Trace(t *testing.T) {
+	var rcs RelabelConfigs
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- source_labels: [host]
+  regex: "([^.]+)\\..+"
+  target_label: host
+  replacement: $1
+- source_labels: [host]
+  regex: "nomatch"
+  target_label: unused
+- source_labels: [host]
+  regex: "test.*"
+  action: drop
+- source_labels: [host]
+  target_label: never
+`), &rcs))
+	labels := FromMap(map[string]string{"host": "server1.example.com"})
+	newLabels, steps, err := rcs.Trace(labels)
+	require.NoError(t, err)
+	require.Len(t, steps, 4)
+	require.Equal(t, RelabelStep{Index: 0, Action: Replace, Value: "server1.example.com", Matched: true, Added: FromMap(map[string]string{"host": "server1"}), Removed: labels}, steps[0])
+	require.Equal(t, RelabelStep{Index: 1, Action: Replace, Value: "server1"}, steps[1])
+	require.Equal(t, RelabelStep{Index: 2, Action: Drop, Value: "server1"}, steps[2])
+	require.Equal(t, FromMap(map[string]string{"host": "server1", "never": "server1"}), newLabels)
+	processed, err := rcs.Process(labels)
+	require.NoError(t, err)
+	require.Equal(t, newLabels, processed)
+
+	newLabels, steps, err = rcs.Trace(FromMap(map[string]string{"host": "test1.example.com"}))
+	require.NoError(t, err)
+	require.Nil(t, newLabels)
+	require.Len(t, steps, 3)
+	require.Equal(t, RelabelStep{Index: 2, Action: Drop, Value: "test1", Matched: true, Dropped: true}, steps[2])
+}
+
+func TestMetricGenerators_DuplicatePolicy(t *testing.T) {
+	data := []byte(`{"disks":[{"host":"a","used":1},{"host":"a","used":4},{"host":"a","used":7},{"host":"b","used":2}]}`)
+	for policy, expected := range map[DuplicatePolicy]float64{
+		DuplicateError: 1,
+		DuplicateFirst: 1,
+		DuplicateLast:  7,
+		DuplicateSum:   12,
+		DuplicateMax:   7,
+		DuplicateMin:   1,
+		DuplicateAvg:   4,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			var c CollectConfig
+			require.NoError(t, yaml.Unmarshal([]byte(fmt.Sprintf(`
+name: duplicate-%s
+data_format: json
+datasource:
+  - type: file
+    url: disks.json
+metrics:
+  - name: disk_used
+    duplicate_policy: %s
+    match:
+      datapoint: disks
+      labels:
+        __value__: used
+    relabel_configs:
+      - regex: __.*|host
+        action: labelkeep
+`, policy, policy)), &c))
+			c.SetLogger(log.NewNopLogger())
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(&dataCollector{collect: &c, data: data})
+			families, err := reg.Gather()
+			require.NoError(t, err)
+			require.Len(t, families, 1)
+			values := map[string]float64{}
+			for _, m := range families[0].GetMetric() {
+				values[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+			}
+			require.Equal(t, map[string]float64{"a": expected, "b": 2}, values)
+			require.Equal(t, float64(2), testutil.ToFloat64(duplicateSeries.WithLabelValues(c.Name, "disk_used", string(policy))))
+		})
+	}
+
+	var mc MetricConfig
+	require.Error(t, yaml.Unmarshal([]byte("name: m\nmetric_type: histogram\nduplicate_policy: sum\n"), &mc))
+	require.Error(t, yaml.Unmarshal([]byte("name: m\nduplicate_policy: unknown\n"), &mc))
+
+	// series of the same name with different label names are dropped
+	merger := newSeriesMerger(false)
+	for _, labels := range []map[string]string{{"host": "a"}, {"host": "b", "disk": "sda"}} {
+		m := NewMetricGenerator(log.NewNopLogger(), "disk_used", Gauge)
+		m.Labels = FromMap(labels)
+		m.Labels.Append(LabelMetricName, "disk_used")
+		m.Labels.Append(LabelMetricValue, "1")
+		metrics, errs := m.prometheusMetrics()
+		require.Empty(t, errs)
+		err := merger.add(m, metrics[0])
+		if labels["host"] == "b" {
+			require.EqualError(t, err, "series of metric disk_used has label names [disk,host] instead of [host] of the previous series")
+		} else {
+			require.NoError(t, err)
+		}
+	}
+	require.Len(t, merger.metrics(), 1)
+}
+
+const openMetricsContent = `# TYPE acme_http_router_request_seconds summary
+# UNIT acme_http_router_request_seconds seconds
+# HELP acme_http_router_request_seconds Latency though all of ACME's \"HTTP\" request router.
+acme_http_router_request_seconds_sum{path="/api/v1",method="GET"} 9036.32
+acme_http_router_request_seconds_count{path="/api/v1",method="GET"} 807283.0
+acme_http_router_request_seconds_created{path="/api/v1",method="GET"} 1605281325.0
+# HELP http_requests The total number of HTTP requests.
+# TYPE http_requests counter
+http_requests_total{code="200",path="/a # b{}"} 1027 1395066363.5 # {trace_id="KOO5S4vxi0o"} 1 1395066363.0
+http_requests_created{code="200",path="/a # b{}"} 1395066000.0
+# TYPE request_size_bytes histogram
+request_size_bytes_bucket{le="100"} 2 # {trace_id="oHg5SJYRHA0"} 53
+request_size_bytes_bucket{le="+Inf"} 3
+request_size_bytes_count 3
+request_size_bytes_sum 312
+request_size_bytes_created 1605281325.0
+# TYPE queue_size_bytes gaugehistogram
+queue_size_bytes_bucket{le="1024"} 4
+queue_size_bytes_bucket{le="+Inf"} 5
+queue_size_bytes_gcount 5
+queue_size_bytes_gsum 2048
+# TYPE build info
+build_info{version="1.2.3"} 1
+# TYPE feature stateset
+feature{feature="a"} 1
+feature{feature="b"} 0
+# TYPE temperature unknown
+temperature 21.5
+# EOF
+`
+
+func TestCollectConfig_GetDatapointsByOpenMetrics(t *testing.T) {
+	require.True(t, isOpenMetrics([]byte(openMetricsContent)))
+	require.False(t, isOpenMetrics([]byte(promContent)))
+	mc := MetricConfig{Name: "openmetrics"}
+	datapoints := map[string][]Datapoint{}
+	for _, dp := range mc.GetDatapointsByPrometheus(log.NewNopLogger(), []byte(openMetricsContent)) {
+		datapoints[dp[LabelMetricName]] = append(datapoints[dp[LabelMetricName]], dp)
+	}
+	require.Len(t, datapoints, 7)
+
+	summary := datapoints["acme_http_router_request_seconds"]
+	require.Len(t, summary, 1)
+	require.Equal(t, string(Summary), summary[0][LabelMetricType])
+	require.Equal(t, "807283", summary[0][LabelMetricCount])
+	require.Equal(t, `Latency though all of ACME's "HTTP" request router.`, summary[0][LabelMetricHelp])
+
+	counter := datapoints["http_requests_total"]
+	require.Len(t, counter, 1)
+	require.Equal(t, string(Counter), counter[0][LabelMetricType])
+	require.Equal(t, "1027", counter[0][LabelMetricValue])
+	require.Equal(t, "1395066363500", counter[0][LabelMetricTime])
+	require.Equal(t, "/a # b{}", counter[0]["path"])
+	require.Equal(t, "The total number of HTTP requests.", counter[0][LabelMetricHelp])
+
+	histogram := datapoints["request_size_bytes"]
+	require.Len(t, histogram, 1)
+	require.Equal(t, string(Histogram), histogram[0][LabelMetricType])
+	require.Equal(t, "100,+Inf", histogram[0][LabelMetricBuckets])
+	require.Equal(t, "2,3", histogram[0][LabelMetricBucketCounts])
+	require.Equal(t, "312", histogram[0][LabelMetricSum])
+
+	gaugeHistogram := datapoints["queue_size_bytes"]
+	require.Len(t, gaugeHistogram, 1)
+	require.Equal(t, string(Histogram), gaugeHistogram[0][LabelMetricType])
+	require.Equal(t, "5", gaugeHistogram[0][LabelMetricCount])
+	require.Equal(t, "2048", gaugeHistogram[0][LabelMetricSum])
+
+	require.Equal(t, string(Gauge), datapoints["build_info"][0][LabelMetricType])
+	require.Equal(t, "1.2.3", datapoints["build_info"][0]["version"])
+	require.Len(t, datapoints["feature"], 2)
+	require.Equal(t, "21.5", datapoints["temperature"][0][LabelMetricValue])
+}
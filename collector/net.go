@@ -23,6 +23,9 @@ import (
 	"gopkg.in/yaml.v3"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -94,15 +97,25 @@ func (t *NetConfig) UnmarshalYAML(value *yaml.Node) error {
 	if t.MaxConnectTime == 0 {
 		t.MaxConnectTime = time.Second * 3
 	}
-	if t.protocol == "udp" && t.TLSConfig != nil {
+	if (t.protocol == "udp" || t.protocol == "unixgram") && t.TLSConfig != nil {
 		return fmt.Errorf("unknown protocol: tls over %s ", t.protocol)
 	}
-	if t.protocol != "udp" && t.protocol != "tcp" {
+	if t.protocol != "udp" && t.protocol != "tcp" && t.protocol != "unix" && t.protocol != "unixgram" {
 		return fmt.Errorf("unknown protocol: %s", t.protocol)
 	}
 	return nil
 }
 
+func (t NetConfig) secrets() []string {
+	var values []string
+	for _, send := range t.Send {
+		if len(send.MsgFile) > 0 {
+			values = append(values, send.Msg)
+		}
+	}
+	return values
+}
+
 func (t NetConfig) GetStream(ctx context.Context, _, targetURL string) (io.ReadCloser, error) {
 	logger, ok := ctx.Value(LoggerContextName).(log.Logger)
 	if !ok {
@@ -110,7 +123,17 @@ func (t NetConfig) GetStream(ctx context.Context, _, targetURL string) (io.ReadC
 	}
 	var conn net.Conn
 	var err error
-	if t.TLSConfig != nil {
+	if t.protocol == "unix" || t.protocol == "unixgram" {
+		// the url of a socket is its path, optionally prefixed with the scheme
+		targetURL = strings.TrimPrefix(targetURL, t.protocol+"://")
+	}
+	if t.protocol == "unixgram" {
+		if t.TLSConfig != nil {
+			err = fmt.Errorf("unknown protocol: tls over %s ", t.protocol)
+		} else {
+			conn, err = dialUnixgram(targetURL)
+		}
+	} else if t.TLSConfig != nil {
 		var tlsConfig *tls.Config
 		tlsConfig, err = config.NewTLSConfig(t.TLSConfig)
 		if err != nil {
@@ -118,7 +141,7 @@ func (t NetConfig) GetStream(ctx context.Context, _, targetURL string) (io.ReadC
 		}
 		if t.protocol == "udp" {
 			err = fmt.Errorf("unknown protocol: tls over %s ", t.protocol)
-		} else if t.protocol == "tcp" {
+		} else if t.protocol == "tcp" || t.protocol == "unix" {
 			d := tls.Dialer{NetDialer: &net.Dialer{Timeout: t.MaxConnectTime}, Config: tlsConfig}
 			conn, err = d.DialContext(ctx, t.protocol, targetURL)
 		} else {
@@ -151,9 +174,34 @@ func (t NetConfig) GetStream(ctx context.Context, _, targetURL string) (io.ReadC
 	return NewConnReader(conn, *t.MaxTransferTime), nil
 }
 
+var unixgramSequence uint64
+
+// unixgramConn is a unixgram connection bound to a temporary socket file, so that the server can reply to it.
+type unixgramConn struct {
+	*net.UnixConn
+	path string
+}
+
+func (c *unixgramConn) Close() error {
+	defer os.Remove(c.path)
+	return c.UnixConn.Close()
+}
+
+func dialUnixgram(path string) (net.Conn, error) {
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d-%d.sock", ExporterName, os.Getpid(), atomic.AddUint64(&unixgramSequence, 1)))
+	conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"}, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.Remove(local)
+		return nil, err
+	}
+	return &unixgramConn{UnixConn: conn, path: local}, nil
+}
+
 type SendConfig struct {
-	Msg   string        `yaml:"msg,omitempty"`
-	Delay time.Duration `yaml:"delay,omitempty"`
+	Msg string `yaml:"msg,omitempty"`
+	// MsgFile is the file that contains the message, it is read when the configuration is loaded.
+	MsgFile string        `yaml:"msg_file,omitempty"`
+	Delay   time.Duration `yaml:"delay,omitempty"`
 }
 
 func (s *SendConfig) UnmarshalYAML(value *yaml.Node) error {
@@ -166,7 +214,20 @@ func (s *SendConfig) UnmarshalYAML(value *yaml.Node) error {
 		return nil
 	} else {
 		type plain SendConfig
-		return value.Decode((*plain)(s))
+		if err := value.Decode((*plain)(s)); err != nil {
+			return err
+		}
+		if len(s.MsgFile) > 0 {
+			if len(s.Msg) > 0 {
+				return fmt.Errorf("at most one of msg and msg_file must be configured")
+			}
+			msg, err := readSecretFile("msg_file", s.MsgFile)
+			if err != nil {
+				return err
+			}
+			s.Msg = msg
+		}
+		return nil
 	}
 }
 
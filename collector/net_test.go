@@ -24,7 +24,7 @@ import (
 	"fmt"
 	"github.com/MicroOps-cn/data_exporter/testings"
 	"github.com/prometheus/common/config"
-	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/assert"
 	"io"
 	"io/ioutil"
 	"math/big"
@@ -111,11 +111,11 @@ func createX509KeyPair(tt *testings.T) (string, string) {
 
 func runServ(t *testings.T, protocol, addr string, tlsConfig *tls.Config) io.Closer {
 	var err error
-	if protocol == "tcp" {
+	if protocol == "tcp" || protocol == "unix" {
 		var listen net.Listener
 		if tlsConfig == nil {
-			t.Logf("listen: tcp://%s", addr)
-			listen, err = net.Listen("tcp", addr)
+			t.Logf("listen: %s://%s", protocol, addr)
+			listen, err = net.Listen(protocol, addr)
 			t.AssertNoError(err)
 		} else {
 			t.Logf("listen: tcps://%s", addr)
@@ -126,7 +126,7 @@ func runServ(t *testings.T, protocol, addr string, tlsConfig *tls.Config) io.Clo
 			for {
 				conn, err := listen.Accept()
 				if err != nil {
-					require.Contains(t.T, err.Error(), "use of closed network connection")
+					assert.Contains(t.T, err.Error(), "use of closed network connection")
 					break
 				}
 				t.Logf("[S]连接已建立, %s", conn.RemoteAddr())
@@ -151,7 +151,7 @@ func runServ(t *testings.T, protocol, addr string, tlsConfig *tls.Config) io.Clo
 					var data [4096]byte
 					n, addr, err := conn.ReadFromUDP(data[:]) // 接收数据
 					if err != nil {
-						require.Contains(t.T, err.Error(), "use of closed network connection")
+						assert.Contains(t.T, err.Error(), "use of closed network connection")
 						break
 					}
 					if strings.TrimSpace(string(data[:n])) == "get_data" {
@@ -169,6 +169,29 @@ func runServ(t *testings.T, protocol, addr string, tlsConfig *tls.Config) io.Clo
 			}()
 			return conn
 		}
+	} else if protocol == "unixgram" {
+		t.Logf("listen: unixgram://%s", addr)
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+		t.AssertNoError(err)
+		go func() {
+			defer conn.Close()
+			for {
+				var data [4096]byte
+				n, addr, err := conn.ReadFromUnix(data[:])
+				if err != nil {
+					assert.Contains(t.T, err.Error(), "use of closed network connection")
+					break
+				}
+				if strings.TrimSpace(string(data[:n])) == "get_data" {
+					for _, s := range strings.Split(exampleData, "\n") {
+						if _, err = conn.WriteToUnix([]byte(fmt.Sprintf("%s\n", s)), addr); err != nil {
+							break
+						}
+					}
+				}
+			}
+		}()
+		return conn
 	}
 	t.AssertNoError(fmt.Errorf("未知的协议配置:: protocol: %s, tls: %v", protocol, tlsConfig))
 	return nil
@@ -181,6 +204,10 @@ func TestTCPNetConfig(t *testing.T) {
 func TestUDPNetConfig(t *testing.T) {
 	testNetConfig(t, "udp", runServ)
 }
+func TestUnixNetConfig(t *testing.T) {
+	testNetConfig(t, "unix", runServ)
+	testNetConfig(t, "unixgram", runServ)
+}
 
 func newInt64(v int64) *int64 {
 	return &v
@@ -257,6 +284,9 @@ func testNetConfig(t *testing.T, network string, listenFunc func(*testings.T, st
 	tt := testings.NewTesting(t)
 
 	addr := fmt.Sprintf("127.0.1.1:%d", rand.Intn(50000)+15530)
+	if strings.HasPrefix(network, "unix") {
+		addr = path.Join(t.TempDir(), network+".sock")
+	}
 	tt.Logf("start %s listen serv: %s", network, addr)
 	listen := listenFunc(tt, network, addr, nil)
 	defer func() {
@@ -0,0 +1,157 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// isOpenMetrics returns true if the data is terminated by "# EOF", which is required by the OpenMetrics format.
+func isOpenMetrics(data []byte) bool {
+	data = bytes.TrimRight(data, " \r\n")
+	return bytes.HasSuffix(data, []byte("# EOF")) && (len(data) == 5 || data[len(data)-6] == '\n')
+}
+
+// openMetricsSuffixes are the suffixes of the samples of a metric family in the OpenMetrics format.
+var openMetricsSuffixes = []string{"_total", "_created", "_bucket", "_count", "_sum", "_gcount", "_gsum", "_info"}
+
+// openMetricsToText converts the OpenMetrics format to the Prometheus text format:
+//   - the counter and info families are renamed to the name of their samples (_total and _info);
+//   - gaugehistogram becomes histogram, info and stateset become gauge, unknown becomes untyped;
+//   - the _created samples and the exemplars are dropped, the timestamps are converted from seconds to milliseconds;
+//   - the UNIT lines and the lines after "# EOF" are dropped.
+func openMetricsToText(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	// the metadata of a family can be in any order, so the types are read first
+	types := map[string]string{}
+	for _, line := range lines {
+		if fields := strings.SplitN(strings.TrimSuffix(line, "\r"), " ", 4); len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" {
+			types[fields[2]] = fields[3]
+		}
+	}
+	var out bytes.Buffer
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "# EOF" {
+			break
+		} else if len(line) == 0 {
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 3 || fields[0] != "#" {
+				continue
+			}
+			name, typ := fields[2], types[fields[2]]
+			switch fields[1] {
+			case "TYPE":
+				out.WriteString("# TYPE " + openMetricsFamilyName(name, typ) + " " + openMetricsTextType(typ) + "\n")
+			case "HELP":
+				help := ""
+				if len(fields) == 4 {
+					// \" is not an escape sequence of the text format
+					help = strings.ReplaceAll(fields[3], `\"`, `"`)
+				}
+				out.WriteString("# HELP " + openMetricsFamilyName(name, typ) + " " + help + "\n")
+			}
+			continue
+		}
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ "); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		if family, typ, suffix := openMetricsFamily(name, types); len(family) > 0 {
+			if suffix == "_created" && typ != "gauge" && typ != "unknown" {
+				continue
+			} else if typ == "gaugehistogram" && (suffix == "_gcount" || suffix == "_gsum") {
+				name = family + strings.Replace(suffix, "_g", "_", 1)
+			}
+		}
+		labels := ""
+		if strings.HasPrefix(rest, "{") {
+			end := labelsEnd(rest)
+			labels, rest = rest[:end], rest[end:]
+		}
+		// the exemplar follows the value and the timestamp
+		if i := strings.Index(rest, " # "); i >= 0 {
+			rest = rest[:i]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 2 {
+			if ts, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				fields[1] = strconv.FormatInt(int64(math.Round(ts*1000)), 10)
+			}
+		}
+		out.WriteString(name + labels + " " + strings.Join(fields, " ") + "\n")
+	}
+	return out.Bytes()
+}
+
+// openMetricsFamily returns the family of the sample, its type and the suffix of the sample name.
+func openMetricsFamily(name string, types map[string]string) (family, typ, suffix string) {
+	if typ, ok := types[name]; ok {
+		return name, typ, ""
+	}
+	for _, suffix = range openMetricsSuffixes {
+		if family = strings.TrimSuffix(name, suffix); family != name {
+			if typ, ok := types[family]; ok {
+				return family, typ, suffix
+			}
+		}
+	}
+	return "", "", ""
+}
+
+func openMetricsFamilyName(name, typ string) string {
+	switch typ {
+	case "counter":
+		if !strings.HasSuffix(name, "_total") {
+			return name + "_total"
+		}
+	case "info":
+		return name + "_info"
+	}
+	return name
+}
+
+func openMetricsTextType(typ string) string {
+	switch typ {
+	case "counter", "gauge", "histogram", "summary":
+		return typ
+	case "gaugehistogram":
+		return "histogram"
+	case "info", "stateset":
+		return "gauge"
+	default:
+		return "untyped"
+	}
+}
+
+// labelsEnd returns the index after the closing brace of the label set, the label values may contain braces.
+func labelsEnd(s string) int {
+	inQuote := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case inQuote && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && s[i] == '}':
+			return i + 1
+		}
+	}
+	return len(s)
+}
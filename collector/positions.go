@@ -0,0 +1,268 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/hpcloud/tail"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	positionsPath       string
+	positionsSyncPeriod time.Duration
+	positionsOnce       sync.Once
+	positions           = &Positions{entries: map[positionKey]Position{}}
+)
+
+type positionKey struct {
+	collect string
+	path    string
+}
+
+// Position is the read offset of a file tailed by a stream datasource.
+type Position struct {
+	Collect string `yaml:"collect"`
+	Path    string `yaml:"path"`
+	Inode   uint64 `yaml:"inode"`
+	Offset  int64  `yaml:"offset"`
+}
+
+// Positions keeps the read offsets of the files tailed by stream datasources, so that they can be resumed
+// after a restart.
+type Positions struct {
+	path    string
+	entries map[positionKey]Position
+	dirty   bool
+	mux     sync.Mutex
+}
+
+func getPositions(logger log.Logger) *Positions {
+	positionsOnce.Do(func() {
+		if len(positionsPath) == 0 {
+			return
+		}
+		positions.path = positionsPath
+		if err := positions.load(); err != nil {
+			level.Error(logger).Log("msg", "failed to load positions file, all files will be read from the configured whence", "path", positionsPath, "err", err)
+		}
+	})
+	if len(positions.path) == 0 {
+		return nil
+	}
+	return positions
+}
+
+func (p *Positions) load() error {
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var file struct {
+		Positions []Position `yaml:"positions"`
+	}
+	if err = yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid positions file: %s", err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, pos := range file.Positions {
+		p.entries[positionKey{collect: pos.Collect, path: pos.Path}] = pos
+	}
+	return nil
+}
+
+func (p *Positions) Get(collect, path string) (Position, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	pos, ok := p.entries[positionKey{collect: collect, path: path}]
+	return pos, ok
+}
+
+func (p *Positions) Put(pos Position) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	key := positionKey{collect: pos.Collect, path: pos.Path}
+	if p.entries[key] != pos {
+		p.entries[key] = pos
+		p.dirty = true
+	}
+}
+
+// Prune deletes the positions of the collect whose path matches the pattern but is not in matched, so that the
+// positions of the rotated or deleted files are not kept forever.
+func (p *Positions) Prune(collect, pattern string, matched map[string]bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for key := range p.entries {
+		if key.collect != collect || matched[key.path] {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, key.path); ok {
+			delete(p.entries, key)
+			p.dirty = true
+		}
+	}
+}
+
+// Sync writes the positions to the file if they have changed since the last sync.
+func (p *Positions) Sync() error {
+	p.mux.Lock()
+	if !p.dirty {
+		p.mux.Unlock()
+		return nil
+	}
+	var file struct {
+		Positions []Position `yaml:"positions"`
+	}
+	for _, pos := range p.entries {
+		file.Positions = append(file.Positions, pos)
+	}
+	p.dirty = false
+	p.mux.Unlock()
+	sort.Slice(file.Positions, func(i, j int) bool {
+		if file.Positions[i].Collect != file.Positions[j].Collect {
+			return file.Positions[i].Collect < file.Positions[j].Collect
+		}
+		return file.Positions[i].Path < file.Positions[j].Path
+	})
+	data, err := yaml.Marshal(&file)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it, so that the positions file is never partially written.
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.path)
+}
+
+// StartPositionsSync periodically writes the positions of the stream file datasources to the file specified
+// by --file.positions-path. The returned function stops the sync and writes the positions one last time.
+func StartPositionsSync(logger log.Logger) (stop func()) {
+	p := getPositions(logger)
+	if p == nil {
+		return func() {}
+	}
+	if positionsSyncPeriod <= 0 {
+		positionsSyncPeriod = time.Second * 10
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(positionsSyncPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := p.Sync(); err != nil {
+					level.Error(logger).Log("msg", "failed to write positions file", "path", p.path, "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if err := p.Sync(); err != nil {
+			level.Error(logger).Log("msg", "failed to write positions file", "path", p.path, "err", err)
+		}
+	}
+}
+
+// resumeLocation returns the location from which the file should be tailed. If the file has been rotated
+// (inode changed) or truncated (size less than the offset) since the position was saved, it is read from
+// the beginning.
+func resumeLocation(logger log.Logger, collect, path string, whence int) *tail.SeekInfo {
+	p := getPositions(logger)
+	if p == nil {
+		return &tail.SeekInfo{Whence: whence}
+	}
+	pos, ok := p.Get(collect, path)
+	if !ok {
+		return &tail.SeekInfo{Whence: whence}
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return &tail.SeekInfo{Whence: whence}
+	}
+	if getInode(stat) != pos.Inode {
+		level.Info(logger).Log("msg", "file has been rotated since the last position was saved, read from the beginning", "path", path)
+		return &tail.SeekInfo{Whence: io.SeekStart}
+	} else if stat.Size() < pos.Offset {
+		level.Info(logger).Log("msg", "file has been truncated since the last position was saved, read from the beginning", "path", path)
+		return &tail.SeekInfo{Whence: io.SeekStart}
+	}
+	level.Debug(logger).Log("msg", "resume reading file", "path", path, "offset", pos.Offset)
+	return &tail.SeekInfo{Offset: pos.Offset, Whence: io.SeekStart}
+}
+
+// positionTracker saves the position of the file after each line is processed.
+type positionTracker struct {
+	*tail.Tail
+	collect string
+	// position of the line being processed, nil if there is none
+	pending *Position
+	mux     sync.Mutex
+}
+
+func (t *positionTracker) commit() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.pending != nil {
+		positions.Put(*t.pending)
+		t.pending = nil
+	}
+}
+
+// ReadLine commits the position of the previous line, which has been processed when the next line is read.
+func (t *positionTracker) ReadLine() ([]byte, error) {
+	t.commit()
+	line, err := t.Tail.ReadLine()
+	if err == nil {
+		if fi, offset := t.Tail.Position(); fi != nil {
+			t.mux.Lock()
+			t.pending = &Position{Collect: t.collect, Path: t.Tail.Filename, Inode: getInode(fi), Offset: offset}
+			t.mux.Unlock()
+		}
+	}
+	return line, err
+}
+
+func (t *positionTracker) Close() error {
+	t.commit()
+	return t.Tail.Close()
+}
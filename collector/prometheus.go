@@ -0,0 +1,173 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"github.com/MicroOps-cn/data_exporter/pkg/wrapper"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (mc *MetricConfig) BuildPrometheusMatch(pointPrefix string) (err error) {
+	if len(mc.Match.Datapoint) > 0 {
+		mc.Match.datapointRegexp, err = regexCompile("^(?:"+mc.Match.Datapoint+")$", false, pointPrefix+".Datapoint")
+	}
+	return err
+}
+
+// FormatFloat formats a value as the text exposition format does, e.g. "+Inf" for the upper bound of the last bucket.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func joinFloats(fs []float64) string {
+	vals := make([]string, len(fs))
+	for i, f := range fs {
+		vals[i] = FormatFloat(f)
+	}
+	return strings.Join(vals, ",")
+}
+
+// WalkSamples calls fn for every sample of the families, in the order of the text exposition format. The histograms and
+// summaries are split into their _bucket, _sum and _count samples, extra is the le or quantile label of the sample.
+func WalkSamples(families []*dto.MetricFamily, fn func(family *dto.MetricFamily, metric *dto.Metric, name string, value float64, extra *dto.LabelPair)) {
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			add := func(suffix string, value float64, extraName, extraValue string) {
+				var extra *dto.LabelPair
+				if len(extraName) > 0 {
+					extra = &dto.LabelPair{Name: &extraName, Value: &extraValue}
+				}
+				fn(family, m, family.GetName()+suffix, value, extra)
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue(), "", "")
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue(), "", "")
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add("", q.GetValue(), model.QuantileLabel, FormatFloat(q.GetQuantile()))
+				}
+				add("_sum", m.GetSummary().GetSampleSum(), "", "")
+				add("_count", float64(m.GetSummary().GetSampleCount()), "", "")
+			case dto.MetricType_HISTOGRAM:
+				hasInf := false
+				for _, b := range m.GetHistogram().GetBucket() {
+					hasInf = hasInf || math.IsInf(b.GetUpperBound(), +1)
+					add("_bucket", float64(b.GetCumulativeCount()), model.BucketLabel, FormatFloat(b.GetUpperBound()))
+				}
+				if !hasInf {
+					add("_bucket", float64(m.GetHistogram().GetSampleCount()), model.BucketLabel, "+Inf")
+				}
+				add("_sum", m.GetHistogram().GetSampleSum(), "", "")
+				add("_count", float64(m.GetHistogram().GetSampleCount()), "", "")
+			default:
+				add("", m.GetUntyped().GetValue(), "", "")
+			}
+		}
+	}
+}
+
+// GetDatapointsByPrometheus parses the Prometheus text exposition format or the OpenMetrics format. Each sample (or
+// each histogram/summary series) becomes a datapoint, the original metric type is kept in the __type__ label.
+func (mc *MetricConfig) GetDatapointsByPrometheus(logger log.Logger, data []byte) []Datapoint {
+	var parser expfmt.TextParser
+	text := data
+	if isOpenMetrics(data) {
+		text = openMetricsToText(data)
+	}
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(text))
+	if err != nil {
+		collectErrorCount.WithLabelValues("metric", mc.Name).Inc()
+		level.Error(logger).Log("msg", "failed to parse prometheus data.", "err", err, "data", string(wrapper.Limit[byte](data, 256, wrapper.PosCenter, []byte(" ... ")...)))
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		if mc.Match.datapointRegexp == nil || mc.Match.datapointRegexp.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	level.Debug(logger).Log("title", "Datapoint Match by Prometheus", "data", string(wrapper.Limit[byte](data, 256, wrapper.PosCenter, []byte(" ... ")...)), "exp", mc.Match.Datapoint, "resultCount", len(names))
+	var results []Datapoint
+	for _, name := range names {
+		family := families[name]
+		for _, m := range family.GetMetric() {
+			dp := Datapoint{LabelMetricName: name}
+			if family.Help != nil {
+				dp[LabelMetricHelp] = family.GetHelp()
+			}
+			for _, lp := range m.GetLabel() {
+				dp[lp.GetName()] = lp.GetValue()
+			}
+			if m.TimestampMs != nil {
+				dp[LabelMetricTime] = strconv.FormatInt(m.GetTimestampMs(), 10)
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				dp[LabelMetricType] = string(Counter)
+				dp[LabelMetricValue] = FormatFloat(m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				dp[LabelMetricType] = string(Gauge)
+				dp[LabelMetricValue] = FormatFloat(m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				dp[LabelMetricType] = string(Gauge)
+				dp[LabelMetricValue] = FormatFloat(m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				var bounds, counts []float64
+				for _, b := range m.GetHistogram().GetBucket() {
+					bounds = append(bounds, b.GetUpperBound())
+					counts = append(counts, float64(b.GetCumulativeCount()))
+				}
+				dp[LabelMetricType] = string(Histogram)
+				dp[LabelMetricBuckets] = joinFloats(bounds)
+				dp[LabelMetricBucketCounts] = joinFloats(counts)
+				dp[LabelMetricCount] = strconv.FormatUint(m.GetHistogram().GetSampleCount(), 10)
+				dp[LabelMetricSum] = FormatFloat(m.GetHistogram().GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				var quantiles, vals []float64
+				for _, q := range m.GetSummary().GetQuantile() {
+					quantiles = append(quantiles, q.GetQuantile())
+					vals = append(vals, q.GetValue())
+				}
+				dp[LabelMetricType] = string(Summary)
+				dp[LabelMetricQuantiles] = joinFloats(quantiles)
+				dp[LabelMetricQuantileValues] = joinFloats(vals)
+				dp[LabelMetricCount] = strconv.FormatUint(m.GetSummary().GetSampleCount(), 10)
+				dp[LabelMetricSum] = FormatFloat(m.GetSummary().GetSampleSum())
+			}
+			labels := make(map[string]string, len(mc.Match.Labels))
+			for label, source := range mc.Match.Labels {
+				if val, ok := dp[source]; ok {
+					level.Debug(logger).Log("title", "Label Match by Prometheus", "exp", source, "result", val, "label", label)
+					labels[label] = val
+				}
+			}
+			for label, val := range labels {
+				dp[label] = val
+			}
+			results = append(results, dp)
+		}
+	}
+	return results
+}
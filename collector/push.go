@@ -0,0 +1,72 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-kit/log"
+	"gopkg.in/yaml.v3"
+	"time"
+)
+
+// PushConfig enables the push endpoint of the collect, which receives data in the data_format of the collect.
+type PushConfig struct {
+	// TTL is the time after which the pushed series that have not been pushed again are deleted, the ttl of the
+	// metric applies if it is 0.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// MaxContentLength is the maximum size in bytes of a pushed body, defaults to 102400000.
+	MaxContentLength int64 `yaml:"max_content_length,omitempty"`
+}
+
+func (p *PushConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain PushConfig
+	if err := value.Decode((*plain)(p)); err != nil {
+		return err
+	}
+	if p.TTL < 0 {
+		return fmt.Errorf("push ttl cannot be negative: %s", p.TTL)
+	}
+	if p.MaxContentLength == 0 {
+		p.MaxContentLength = DefaultMaxContent
+	} else if p.MaxContentLength < 0 {
+		return fmt.Errorf("push max_content_length cannot be negative: %d", p.MaxContentLength)
+	}
+	return nil
+}
+
+var ErrPushDisabled = errors.New("push is not enabled")
+
+// PushData updates the series of the collect with the metrics of the pushed data, like the lines read from a stream.
+// The pushed series are kept until they expire. It returns the number of metrics generated from the data.
+func (c *CollectConfig) PushData(logger log.Logger, data []byte) (samples int, err error) {
+	if c.Push == nil {
+		return 0, ErrPushDisabled
+	}
+	_, samples, err = c.handleData(logger, data, c.RelabelConfigs, c.Push.TTL)
+	return samples, err
+}
+
+// KeepPushedSeries makes the collects with push enabled share the series of the collect with the same name in prev, so
+// that the pushed series are kept across reloads. It must be called before the collects are started.
+func (c Collects) KeepPushedSeries(prev Collects) {
+	for idx := range c {
+		if c[idx].Push == nil {
+			continue
+		}
+		if old := prev.Get(c[idx].Name); old != nil && old.Push != nil && old.metrics != nil {
+			c[idx].metrics, old.metricsKept = old.metrics, true
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"github.com/MicroOps-cn/data_exporter/pkg/buffer"
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"time"
+)
+
+var (
+	datasourceScrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_scrape_duration_seconds",
+		Help:      "duration of the last read of the datasource",
+	}, []string{"collect", "datasource"})
+	datasourceSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_success",
+		Help:      "whether the last read of the datasource succeeded, or whether the stream is open in stream mode",
+	}, []string{"collect", "datasource"})
+	datasourceLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_last_success_timestamp_seconds",
+		Help:      "unix time of the last successful read of the datasource, or of the last line read in stream mode",
+	}, []string{"collect", "datasource"})
+	datasourceBytesRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_bytes_read_total",
+		Help:      "number of bytes read from the datasource, without the line separators in line and stream mode",
+	}, []string{"collect", "datasource"})
+	datasourceLinesRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_lines_read_total",
+		Help:      "number of lines read from the datasource in line and stream mode",
+	}, []string{"collect", "datasource"})
+	datasourceDatapointsParsed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_datapoints_parsed_total",
+		Help:      "number of datapoints matched in the data of the datasource",
+	}, []string{"collect", "datasource"})
+	datasourceSamplesEmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_samples_emitted_total",
+		Help:      "number of series emitted from the data of the datasource, after relabeling",
+	}, []string{"collect", "datasource"})
+	datasourceStreamReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_stream_reconnects_total",
+		Help:      "number of times the stream of the datasource was reopened",
+	}, []string{"collect", "datasource"})
+	datasourceStreamOffset = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "datasource_stream_offset_bytes",
+		Help:      "offset in the file tailed by the datasource, or number of bytes read since the stream was opened",
+	}, []string{"collect", "datasource", "url"})
+)
+
+// observeScrape records the duration and the result of a read of the datasource.
+func observeScrape(collect, datasource string, start time.Time, err error) {
+	now := time.Now()
+	datasourceScrapeDuration.WithLabelValues(collect, datasource).Set(now.Sub(start).Seconds())
+	observeSuccess(collect, datasource, now, err)
+}
+
+func observeSuccess(collect, datasource string, now time.Time, err error) {
+	if err != nil {
+		datasourceSuccess.WithLabelValues(collect, datasource).Set(0)
+		return
+	}
+	datasourceSuccess.WithLabelValues(collect, datasource).Set(1)
+	datasourceLastSuccess.WithLabelValues(collect, datasource).Set(float64(now.UnixNano()) / 1e9)
+}
+
+// observeData records the data read from the datasource, lines is 0 in full mode.
+func observeData(collect, datasource string, bytes, lines, datapoints, samples int) {
+	datasourceBytesRead.WithLabelValues(collect, datasource).Add(float64(bytes))
+	datasourceLinesRead.WithLabelValues(collect, datasource).Add(float64(lines))
+	datasourceDatapointsParsed.WithLabelValues(collect, datasource).Add(float64(datapoints))
+	datasourceSamplesEmitted.WithLabelValues(collect, datasource).Add(float64(samples))
+}
+
+// deleteDatasourceMetrics deletes the series of the datasource from the datasource metrics.
+func deleteDatasourceMetrics(collect, datasource string) {
+	for _, vec := range []interface{ DeleteLabelValues(...string) bool }{
+		datasourceScrapeDuration, datasourceSuccess, datasourceLastSuccess, datasourceBytesRead, datasourceLinesRead,
+		datasourceDatapointsParsed, datasourceSamplesEmitted, datasourceStreamReconnects,
+	} {
+		vec.DeleteLabelValues(collect, datasource)
+	}
+}
+
+// streamOffset returns the offset in the file tailed by the stream, or read if the stream is not a file.
+func streamOffset(stream buffer.ReadLineCloser, read int64) int64 {
+	if tailer, ok := stream.(interface{ Position() (os.FileInfo, int64) }); ok {
+		if fi, offset := tailer.Position(); fi != nil {
+			return offset
+		}
+	}
+	return read
+}
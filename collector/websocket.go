@@ -0,0 +1,483 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	promconfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	DefaultWebSocketPingInterval = time.Second * 30
+	DefaultWebSocketPongTimeout  = time.Second * 10
+)
+
+var (
+	websocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ExporterName,
+		Name:      "websocket_connections",
+		Help:      "number of open websocket connections of the datasource",
+	}, []string{"collect", "datasource"})
+	websocketConnectAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "websocket_connect_attempts_total",
+		Help:      "number of websocket connection attempts of the datasource, by result (success or failure)",
+	}, []string{"collect", "datasource", "result"})
+	websocketMessagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "websocket_messages_received_total",
+		Help:      "number of text and binary messages received from the websocket datasource",
+	}, []string{"collect", "datasource"})
+	websocketKeepaliveTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ExporterName,
+		Name:      "websocket_keepalive_timeouts_total",
+		Help:      "number of websocket connections closed because the server did not answer the pings",
+	}, []string{"collect", "datasource"})
+)
+
+// WebSocketConfig is the config of the ws and wss datasources. The headers, TLS and authentication of the handshake
+// are configured like an HTTP datasource.
+type WebSocketConfig struct {
+	HTTPConfig `yaml:",inline"`
+	// Send are the messages sent after the connection is established, e.g. to subscribe to the telemetry.
+	Send SendConfigs `yaml:"send,omitempty"`
+	// PingInterval is the interval at which ping frames are sent, the connection is closed if nothing is received
+	// within PingInterval + PongTimeout.
+	PingInterval time.Duration `yaml:"ping_interval,omitempty"`
+	PongTimeout  time.Duration `yaml:"pong_timeout,omitempty"`
+}
+
+func NewWebSocketConfig() *WebSocketConfig {
+	return &WebSocketConfig{
+		HTTPConfig:   HTTPConfig{MaxConnectTime: DatasourceDefaultConnectTimeout},
+		PingInterval: DefaultWebSocketPingInterval,
+		PongTimeout:  DefaultWebSocketPongTimeout,
+	}
+}
+
+func (w *WebSocketConfig) UnmarshalYAML(value *yaml.Node) error {
+	// the UnmarshalYAML of the inline HTTPConfig is promoted, so the other fields are decoded separately
+	if err := value.Decode(&w.HTTPConfig); err != nil {
+		return err
+	}
+	obj := struct {
+		Send         *SendConfigs   `yaml:"send,omitempty"`
+		PingInterval *time.Duration `yaml:"ping_interval,omitempty"`
+		PongTimeout  *time.Duration `yaml:"pong_timeout,omitempty"`
+	}{Send: &w.Send, PingInterval: &w.PingInterval, PongTimeout: &w.PongTimeout}
+	if err := value.Decode(&obj); err != nil {
+		return err
+	}
+	if len(w.Body) > 0 {
+		return fmt.Errorf("body is not supported by websocket datasource, use send instead")
+	} else if len(w.Method) > 0 && w.Method != http.MethodGet {
+		return fmt.Errorf("method %s is not supported by websocket datasource", w.Method)
+	} else if len(w.StreamFormat) > 0 {
+		return fmt.Errorf("stream_format is not supported by websocket datasource")
+	}
+	if w.PingInterval == 0 {
+		w.PingInterval = DefaultWebSocketPingInterval
+	} else if w.PingInterval < 0 {
+		return fmt.Errorf("ping_interval cannot be negative: %s", w.PingInterval)
+	}
+	if w.PongTimeout == 0 {
+		w.PongTimeout = DefaultWebSocketPongTimeout
+	} else if w.PongTimeout < 0 {
+		return fmt.Errorf("pong_timeout cannot be negative: %s", w.PongTimeout)
+	}
+	return nil
+}
+
+func (w *WebSocketConfig) secrets() []string {
+	return append(w.HTTPConfig.secrets(), NetConfig{Send: w.Send}.secrets()...)
+}
+
+func (w *WebSocketConfig) GetStream(ctx context.Context, name, targetURL string) (io.ReadCloser, error) {
+	conn, err := w.dial(ctx, "", name, targetURL, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// websocketGUID is the GUID used to compute the Sec-WebSocket-Accept header, defined in RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// handshake sends the upgrade request of the websocket connection.
+func (w *WebSocketConfig) handshake(ctx context.Context, name, targetURL string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(targetURL, "ws://") {
+		targetURL = "http://" + strings.TrimPrefix(targetURL, "ws://")
+	} else if strings.HasPrefix(targetURL, "wss://") {
+		targetURL = "https://" + strings.TrimPrefix(targetURL, "wss://")
+	}
+	// the connection cannot be upgraded over HTTP/2
+	client, targetURL, err := w.newClient(name, targetURL, promconfig.WithHTTP2Disabled())
+	if err != nil {
+		return nil, err
+	}
+	if w.MaxConnectTime > 0 {
+		// the context of the request only bounds the handshake, the upgraded connection is closed by dial
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.MaxConnectTime)
+		defer cancel()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	w.setHeaders(request)
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return nil, fmt.Errorf("invalid HTTP response status code %d, wanted 101: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("the upgraded connection is not writable")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		rwc.Close()
+		return nil, fmt.Errorf("invalid Sec-WebSocket-Accept header of the handshake response")
+	}
+	return rwc, nil
+}
+
+// dial opens the websocket connection, sends the messages of the config and keeps the connection alive until it is
+// closed or the context is done. The messages are read until end_of, a message larger than maxMessageSize (or
+// DefaultMaxContent if it is not greater than 0) is an error.
+func (w *WebSocketConfig) dial(ctx context.Context, collect, name, targetURL, endOf string, maxMessageSize int) (*wsReader, error) {
+	logger, ok := ctx.Value(LoggerContextName).(log.Logger)
+	if !ok {
+		logger = log.NewNopLogger()
+	}
+	rwc, err := w.handshake(ctx, name, targetURL)
+	if err != nil {
+		websocketConnectAttempts.WithLabelValues(collect, name, "failure").Inc()
+		return nil, err
+	}
+	websocketConnectAttempts.WithLabelValues(collect, name, "success").Inc()
+	websocketConnections.WithLabelValues(collect, name).Inc()
+	conn := &wsConn{
+		rwc:            rwc,
+		r:              bufio.NewReader(rwc),
+		maxMessageSize: maxMessageSize,
+		lastRead:       time.Now().UnixNano(),
+		done:           make(chan struct{}),
+		messages:       websocketMessagesReceived.WithLabelValues(collect, name),
+		onClose:        websocketConnections.WithLabelValues(collect, name).Dec,
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-conn.done:
+		}
+	}()
+	go conn.keepalive(w.PingInterval, w.PongTimeout, func() {
+		level.Warn(logger).Log("msg", "websocket keepalive timeout, closing the connection", "url", targetURL)
+		websocketKeepaliveTimeouts.WithLabelValues(collect, name).Inc()
+	})
+	if len(w.Send) > 0 {
+		go func() {
+			for _, sendConfig := range w.Send {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := conn.writeFrame(wsText, []byte(sendConfig.Msg)); err != nil {
+					level.Error(logger).Log("msg", "failed to send msg", "err", err)
+					return
+				} else if sendConfig.Delay > 0 {
+					time.Sleep(sendConfig.Delay)
+				}
+			}
+		}()
+	}
+	return &wsReader{wsConn: conn, ctx: ctx, endOf: []byte(endOf)}, nil
+}
+
+// the opcodes of the websocket frames.
+const (
+	wsContinuation byte = 0x0
+	wsText         byte = 0x1
+	wsBinary       byte = 0x2
+	wsClose        byte = 0x8
+	wsPing         byte = 0x9
+	wsPong         byte = 0xA
+)
+
+// wsConn is the client side of a websocket connection.
+type wsConn struct {
+	rwc            io.ReadWriteCloser
+	r              *bufio.Reader
+	wmux           sync.Mutex
+	maxMessageSize int
+	// unix time in nanoseconds of the last frame received
+	lastRead  int64
+	done      chan struct{}
+	closeOnce sync.Once
+	messages  prometheus.Counter
+	onClose   func()
+}
+
+// writeFrame writes a masked frame, as required for the frames sent by a client.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// messageLimit returns the maximum size of a message, DefaultMaxContent if maxMessageSize is not greater than 0.
+func (c *wsConn) messageLimit() int {
+	if c.maxMessageSize > 0 {
+		return c.maxMessageSize
+	}
+	return DefaultMaxContent
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2, 8)
+	if _, err = io.ReadFull(c.r, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0F
+	masked, size := header[1]&0x80 != 0, uint64(header[1]&0x7F)
+	switch size {
+	case 126:
+		if _, err = io.ReadFull(c.r, header[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.r, header[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(header[:8])
+	}
+	if opcode >= wsClose && (size > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("invalid websocket control frame")
+	} else if limit := c.messageLimit(); size > uint64(limit) {
+		// the size is sent by the server, it is checked before the payload is allocated
+		return false, 0, nil, fmt.Errorf("websocket message is too large: more than %d bytes", limit)
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(c.r, mask); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next text or binary message, the control frames are handled. io.EOF is returned when the
+// server closes the connection.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err = c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if fragmented {
+				return nil, fmt.Errorf("invalid websocket frame: expected a continuation frame")
+			}
+			message, fragmented = payload, true
+		case wsContinuation:
+			if !fragmented {
+				return nil, fmt.Errorf("invalid websocket frame: unexpected continuation frame")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, fmt.Errorf("invalid websocket frame: unknown opcode %d", opcode)
+		}
+		if limit := c.messageLimit(); len(message) > limit {
+			return nil, fmt.Errorf("websocket message is too large: more than %d bytes", limit)
+		}
+		if fin {
+			c.messages.Inc()
+			return message, nil
+		}
+	}
+}
+
+// keepalive sends a ping frame at every interval, the connection is closed if nothing has been received within
+// interval + timeout.
+func (c *wsConn) keepalive(interval, timeout time.Duration, onTimeout func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRead))) > interval+timeout {
+				onTimeout()
+				c.Close()
+				return
+			}
+			if err := c.writeFrame(wsPing, nil); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close sends a close frame and closes the connection.
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.onClose()
+		_ = c.writeFrame(wsClose, []byte{0x03, 0xE8})
+		err = c.rwc.Close()
+	})
+	return err
+}
+
+// wsReader reads the messages of a websocket connection, ReadLine returns a message and Read returns the messages
+// followed by "\n". The message that contains end_of is the last one, truncated before end_of. ReadLine ends without
+// error when the context is done, Read returns the error of the context.
+type wsReader struct {
+	*wsConn
+	ctx     context.Context
+	endOf   []byte
+	ended   bool
+	pending []byte
+}
+
+func (r *wsReader) ReadLine() ([]byte, error) {
+	message, err := r.nextMessage()
+	if err != nil && r.ctx.Err() != nil {
+		return nil, io.EOF
+	}
+	return message, err
+}
+
+func (r *wsReader) nextMessage() ([]byte, error) {
+	if r.ended {
+		return nil, io.EOF
+	}
+	message, err := r.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.endOf) > 0 {
+		if i := bytes.Index(message, r.endOf); i >= 0 {
+			r.ended = true
+			if i == 0 {
+				return nil, io.EOF
+			}
+			message = message[:i]
+		}
+	}
+	return message, nil
+}
+
+func (r *wsReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		line, err := r.nextMessage()
+		if err != nil {
+			if ctxErr := r.ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			return 0, err
+		}
+		r.pending = append(line, '\n')
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -14,6 +14,7 @@
 package config
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/MicroOps-cn/data_exporter/collector"
@@ -23,6 +24,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 )
 
@@ -45,14 +47,23 @@ func RegisterCollector(reg prometheus.Registerer) {
 }
 
 type Config struct {
-	Collects   collector.Collects `yaml:"collects"`
-	cancelFunc context.CancelFunc
-	ctx        context.Context
+	Collects    collector.Collects   `yaml:"collects"`
+	RemoteWrite []*RemoteWriteConfig `yaml:"remote_write,omitempty"`
+	secrets     []string
+	cancelFunc  context.CancelFunc
+	ctx         context.Context
 }
 
 func (c *Config) Init(logger log.Logger) error {
 	c.Collects.SetLogger(logger)
-	return c.Collects.StartStreamCollect(c.ctx)
+	c.Collects.StartDiscovery(c.ctx)
+	c.Collects.StartScheduledCollect(c.ctx)
+	if err := c.Collects.StartStreamCollect(c.ctx); err != nil {
+		// stop the collects that have been started, and release the addresses of their listening datasources
+		c.cancelFunc()
+		return err
+	}
+	return nil
 }
 
 func (c *Config) UnmarshalYAML(value *yaml.Node) error {
@@ -83,7 +94,11 @@ func (sc *SafeConfig) ReloadConfigFromReader(reader io.ReadCloser, logger log.Lo
 	var c = NewConfig()
 	if err = c.loadConfigFile(reader); err != nil {
 		return err
-	} else if err = c.Init(logger); err != nil {
+	}
+	if sc.C != nil {
+		c.Collects.KeepPushedSeries(sc.C.Collects)
+	}
+	if err = c.Init(logger); err != nil {
 		return err
 	}
 	sc.Lock()
@@ -115,11 +130,15 @@ func (sc *SafeConfig) ReloadConfig(confPath string, logger log.Logger) (err erro
 	if err = c.LoadConfig(confPath); err != nil {
 		return err
 	}
+	if sc.C != nil {
+		c.Collects.KeepPushedSeries(sc.C.Collects)
+	}
 	if err = c.Init(logger); err != nil {
 		return fmt.Errorf("error init config: %s", err)
 	}
 	if sc.C != nil {
 		sc.C.Collects.StopStreamCollect()
+		sc.C.Collects.DeleteRemovedMetrics(c.Collects)
 		if sc.C.cancelFunc != nil {
 			sc.C.cancelFunc()
 		}
@@ -130,18 +149,53 @@ func (sc *SafeConfig) ReloadConfig(confPath string, logger log.Logger) (err erro
 	return nil
 }
 
-func (c *Config) loadConfigFile(cfgFile io.ReadCloser) error {
+func readConfigFile(name string, cfgFile io.ReadCloser) (*configFile, error) {
 	defer cfgFile.Close()
-	var tmpCfg Config
-	decoder := yaml.NewDecoder(cfgFile)
-	decoder.KnownFields(true)
+	f := &configFile{name: name}
+	data, err := io.ReadAll(cfgFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", f.wrap(err))
+	}
+	if err = yaml.NewDecoder(bytes.NewReader(data)).Decode(&f.node); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", f.wrap(err))
+	}
+	if expandEnvEnabled {
+		if f.secrets, err = expandEnv(&f.node); err != nil {
+			return nil, fmt.Errorf("error expanding config file: %s", f.wrap(err))
+		}
+	}
+	return f, nil
+}
+
+func (c *Config) loadConfigFile(cfgFile io.ReadCloser) error {
+	f, err := readConfigFile("", cfgFile)
+	if err != nil {
+		return err
+	}
+	return c.loadConfigFiles(f)
+}
 
-	if err := decoder.Decode(&tmpCfg); err != nil {
+// loadConfigFiles resolves the references across the files, and decodes them.
+func (c *Config) loadConfigFiles(files ...*configFile) error {
+	defs, err := newDefinitions(files)
+	if err != nil {
 		return fmt.Errorf("error parsing config file: %s", err)
 	}
-	c.Collects = append(c.Collects, tmpCfg.Collects...)
+	for _, f := range files {
+		if err = defs.resolve(f); err != nil {
+			return fmt.Errorf("error parsing config file: %s", err)
+		}
+		var tmpCfg Config
+		if err = f.node.Decode(&tmpCfg); err != nil {
+			return fmt.Errorf("error parsing config file: %s", f.wrap(err))
+		}
+		c.secrets = append(c.secrets, f.secrets...)
+		c.Collects = append(c.Collects, tmpCfg.Collects...)
+		c.RemoteWrite = append(c.RemoteWrite, tmpCfg.RemoteWrite...)
+	}
 	return nil
 }
+
 func (c *Config) LoadConfig(configPath string) error {
 	if stat, err := os.Stat(configPath); err != nil {
 		return err
@@ -151,27 +205,32 @@ func (c *Config) LoadConfig(configPath string) error {
 			if entrys, err := os.ReadDir(configPath); err != nil {
 				return err
 			} else {
+				var files []*configFile
 				for _, entry := range entrys {
 					if !entry.IsDir() {
 						switch path.Ext(entry.Name()) {
 						case ".yml", ".yaml":
 							if f, err := cfgPool.Open(entry.Name()); err != nil {
 								return err
-							} else if err = c.loadConfigFile(f); err != nil {
+							} else if file, err := readConfigFile(filepath.Join(configPath, entry.Name()), f); err != nil {
 								return err
+							} else {
+								files = append(files, file)
 							}
 						}
 
 					}
 				}
+				return c.loadConfigFiles(files...)
 			}
 		} else {
 			if f, err := os.Open(configPath); err != nil {
 				return err
+			} else if file, err := readConfigFile(configPath, f); err != nil {
+				return err
 			} else {
-				return c.loadConfigFile(f)
+				return c.loadConfigFiles(file)
 			}
 		}
 	}
-	return nil
 }
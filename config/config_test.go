@@ -155,3 +155,224 @@ func TestLoadConfig(t *testing.T) {
 	tt.AssertEqual(httpConfig.Headers["Content-Type"], `application/json`)
 
 }
+
+func TestLoadConfigSecrets(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	tt.AssertNoError(os.WriteFile(dir+"/token", []byte("file-token\n"), 0600))
+	tt.AssertNoError(os.WriteFile(dir+"/body", []byte(`{"password":"file-password"}`), 0600))
+	tt.AssertNoError(os.WriteFile(dir+"/msg", []byte("AUTH file-msg\n"), 0600))
+	enableExpandEnv(t)
+	t.Setenv("TEST_API_KEY", "env-key")
+	t.Setenv("TEST_DATA_FORMAT", "json")
+	content := `
+collects:
+- name: "test-secrets"
+  data_format: ${TEST_DATA_FORMAT}
+  datasource:
+    - type: http
+      url: "https://${TEST_API_HOST:-examples.com}/xxx.json?key=${TEST_API_KEY}"
+      config:
+        body_file: "` + dir + `/body"
+        headers_file: {"Authorization": "` + dir + `/token"}
+    - type: tcp
+      url: "127.0.0.1:6379"
+      config:
+        send:
+          - msg_file: "` + dir + `/msg"
+          - msg: "$${NOT_EXPANDED}"
+  # the variables in comments are not expanded: ${TEST_UNSET_IN_COMMENT}
+  relabel_configs:
+    - source_labels: [name]
+      regex: "(?P<name>.+)"
+      target_label: "${name}_copy"
+      replacement: "${name}"
+  metrics:
+    - name: "Point1"
+      match:
+        datapoint: "data"
+`
+	c := NewConfig()
+	tt.AssertNoError(c.loadConfigFile(io.NopCloser(strings.NewReader(content))))
+	tt.AssertEqual(c.Collects[0].DataFormat, collector.Json)
+	tt.AssertEqual(c.Collects[0].RelabelConfigs[0].TargetLabel, "${name}_copy")
+	tt.AssertEqual(c.Collects[0].RelabelConfigs[0].Replacement, "${name}")
+	httpDs := c.Collects[0].Datasource[0]
+	tt.AssertEqual(httpDs.Url, "https://examples.com/xxx.json?key=env-key")
+	httpConfig := httpDs.Config.(*collector.HTTPConfig)
+	tt.AssertEqual(httpConfig.Body, `{"password":"file-password"}`)
+	tt.AssertEqual(httpConfig.Headers["Authorization"], "file-token")
+	netConfig := c.Collects[0].Datasource[1].Config.(*collector.NetConfig)
+	tt.AssertEqual(netConfig.Send[0].Msg, "AUTH file-msg\n")
+	tt.AssertEqual(netConfig.Send[1].Msg, "${NOT_EXPANDED}")
+
+	var buf bytes.Buffer
+	tt.AssertNoError(c.WriteRedacted(&buf))
+	for _, secret := range []string{"env-key", "file-token", "file-password", "file-msg"} {
+		tt.AssertEqual(strings.Contains(buf.String(), secret), false, secret+" is not redacted")
+	}
+	tt.AssertEqual(strings.Contains(buf.String(), "url: <secret>"), true)
+	tt.AssertEqual(strings.Contains(buf.String(), "data_format: <secret>"), true)
+
+	// the values of the variables passed with --config.plain-env are shown
+	plainEnvs = []string{"TEST_DATA_FORMAT"}
+	c = NewConfig()
+	tt.AssertNoError(c.loadConfigFile(io.NopCloser(strings.NewReader(content))))
+	buf.Reset()
+	tt.AssertNoError(c.WriteRedacted(&buf))
+	tt.AssertEqual(strings.Contains(buf.String(), "data_format: json"), true)
+	tt.AssertEqual(strings.Contains(buf.String(), "url: <secret>"), true)
+
+	err := NewConfig().loadConfigFile(io.NopCloser(strings.NewReader(strings.ReplaceAll(content, "TEST_API_KEY", "TEST_UNSET_KEY"))))
+	tt.AssertNotEqual(err, nil)
+	tt.AssertEqual(strings.Contains(err.Error(), `line 7: environment variable "TEST_UNSET_KEY" is not set`), true)
+}
+
+func TestLoadConfigExpandEnvDisabled(t *testing.T) {
+	tt := testings.NewTesting(t)
+	t.Setenv("TEST_API_KEY", "env-key")
+	c := NewConfig()
+	tt.AssertNoError(c.loadConfigFile(io.NopCloser(strings.NewReader(`
+collects:
+- name: "test-expand-env"
+  data_format: "json"
+  datasource:
+    - type: http
+      url: "https://examples.com/xxx.json?key=${TEST_API_KEY}"
+  metrics:
+    - name: "Point1"
+      match:
+        datapoint: "data"
+`))))
+	tt.AssertEqual(c.Collects[0].Datasource[0].Url, "https://examples.com/xxx.json?key=${TEST_API_KEY}")
+}
+
+func enableExpandEnv(t *testing.T) {
+	expandEnvEnabled = true
+	t.Cleanup(func() {
+		expandEnvEnabled, plainEnvs = false, nil
+	})
+}
+
+func TestLoadConfigReferences(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	tt.AssertNoError(os.WriteFile(dir+"/common.yaml", []byte(`
+datasources:
+  - name: "api"
+    type: http
+    url: "https://examples.com/api.json"
+    config:
+      method: POST
+      headers: {"Authorization": "Bearer token"}
+    relabel_configs:
+      - ref: "server"
+relabel_configs:
+  server:
+    - target_label: server
+      replacement: "api"
+metric_templates:
+  - name: "points"
+    metric_type: counter
+    match:
+      datapoint: "data|@expand"
+      labels:
+        __value__: "value"
+        __name__: "name"
+`), 0644))
+	collects := `
+collects:
+  - name: "test-ref"
+    data_format: "json"
+    datasource:
+      - ref: "api"
+        url: "https://examples.com/other.json"
+        config:
+          method: GET
+    relabel_configs:
+      - ref: "server"
+      - target_label: env
+        replacement: "prod"
+    metrics:
+      - ref: "points"
+        name: "Point1"
+`
+	tt.AssertNoError(os.WriteFile(dir+"/collect.yaml", []byte(collects), 0644))
+	c := NewConfig()
+	tt.AssertNoError(c.LoadConfig(dir))
+	tt.AssertEqual(len(c.Collects), 1)
+	collect := &c.Collects[0]
+	ds := collect.Datasource[0]
+	tt.AssertEqual(ds.Name, "api")
+	tt.AssertEqual(ds.Url, "https://examples.com/other.json")
+	httpConfig := ds.Config.(*collector.HTTPConfig)
+	tt.AssertEqual(httpConfig.Method, "GET")
+	tt.AssertEqual(httpConfig.Headers["Authorization"], "Bearer token")
+	tt.AssertEqual(len(ds.RelabelConfigs), 1)
+	tt.AssertEqual(ds.RelabelConfigs[0].TargetLabel, "server")
+	tt.AssertEqual(len(collect.RelabelConfigs), 2)
+	tt.AssertEqual(collect.RelabelConfigs[0].TargetLabel, "server")
+	tt.AssertEqual(collect.RelabelConfigs[1].TargetLabel, "env")
+	tt.AssertEqual(collect.Metrics[0].Name, "Point1")
+	tt.AssertEqual(collect.Metrics[0].MetricType, collector.Counter)
+	tt.AssertEqual(collect.Metrics[0].Match.Datapoint, "data|@expand")
+
+	tt.AssertNoError(os.WriteFile(dir+"/collect.yaml", []byte(strings.ReplaceAll(collects, `ref: "points"`, `ref: "unknown"`)), 0644))
+	err := NewConfig().LoadConfig(dir)
+	tt.AssertNotEqual(err, nil)
+	tt.AssertEqual(strings.Contains(err.Error(), dir+`/collect.yaml:15: unknown metric_template reference "unknown"`), true, err.Error())
+}
+
+func TestResolveSharedDefinitions(t *testing.T) {
+	tt := testings.NewTesting(t)
+	read := func(name, content string) *configFile {
+		f, err := readConfigFile(name, io.NopCloser(strings.NewReader(content)))
+		tt.AssertNoError(err)
+		return f
+	}
+	common := `
+datasources:
+  - name: "api"
+    type: http
+    url: "https://examples.com/api.json"
+    relabel_configs:
+      - ref: "server"
+relabel_configs:
+  server:
+    - target_label: server
+      replacement: "api"
+`
+	collects := `
+collects:
+  - name: "a"
+    data_format: "json"
+    datasource: [{ref: "api"}]
+    metrics: [{name: "a", match: {datapoint: "data"}}]
+  - name: "b"
+    data_format: "json"
+    datasource: [{ref: "api", name: "api-b"}]
+    metrics: [{name: "b", match: {datapoint: "data"}}]
+`
+	c := NewConfig()
+	tt.AssertNoError(c.loadConfigFiles(read("common.yaml", common), read("collect.yaml", collects)))
+	for idx := range c.Collects {
+		rcs := c.Collects[idx].Datasource[0].RelabelConfigs
+		tt.AssertEqual(len(rcs), 1)
+		tt.AssertEqual(rcs[0].TargetLabel, "server")
+	}
+
+	// the definitions are not modified by the resolution of the references
+	files := []*configFile{read("common.yaml", common), read("collect.yaml", collects)}
+	defs, err := newDefinitions(files)
+	tt.AssertNoError(err)
+	tt.AssertNoError(defs.resolve(files[1]))
+	rcs, _ := mappingValue(defs.datasources["api"].node, relabelConfigsKey)
+	ref, _ := mappingValue(rcs.Content[0], referenceKey)
+	tt.AssertNotEqual(ref, nil)
+	tt.AssertEqual(ref.Value, "server")
+
+	// the errors of a definition are reported at the position of the definition
+	err = NewConfig().loadConfigFiles(read("common.yaml", strings.ReplaceAll(common, `- ref: "server"`, `- ref: "unknown"`)), read("collect.yaml", collects))
+	tt.AssertNotEqual(err, nil)
+	tt.AssertEqual(strings.Contains(err.Error(), `common.yaml:7: unknown relabel_configs reference "unknown"`), true, err.Error())
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"regexp"
+)
+
+const redactedSecret = "<secret>"
+
+var (
+	expandEnvEnabled bool
+	plainEnvs        []string
+)
+
+// envPattern matches ${VAR} and ${VAR:-default}, "$$" escapes the expansion.
+var envPattern = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// envExcludedKeys are the keys whose values are not expanded, the relabel replacements and target labels reference
+// the groups of the regex with the same syntax.
+var envExcludedKeys = map[string]bool{"replacement": true, "target_label": true}
+
+// expandEnv replaces the environment variable references in the scalar values of the node. The expanded values that
+// reference a variable that is not passed with --config.plain-env are returned as secrets.
+func expandEnv(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return expandScalarEnv(node)
+	case yaml.MappingNode:
+		var secrets []string
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if envExcludedKeys[node.Content[idx].Value] {
+				continue
+			}
+			values, err := expandEnv(node.Content[idx+1])
+			if err != nil {
+				return nil, err
+			}
+			secrets = append(secrets, values...)
+		}
+		return secrets, nil
+	default:
+		var secrets []string
+		for _, child := range node.Content {
+			values, err := expandEnv(child)
+			if err != nil {
+				return nil, err
+			}
+			secrets = append(secrets, values...)
+		}
+		return secrets, nil
+	}
+}
+
+func expandScalarEnv(node *yaml.Node) ([]string, error) {
+	var err error
+	var secret bool
+	value := envPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+		match := envPattern.FindStringSubmatch(ref)
+		if len(match[1]) > 0 {
+			return ref[1:]
+		}
+		name := match[2]
+		if value, ok := os.LookupEnv(name); ok && (len(value) > 0 || len(match[3]) == 0) {
+			secret = secret || isSecretEnv(name)
+			return value
+		} else if len(match[3]) > 0 {
+			return match[4]
+		} else if err == nil {
+			err = fmt.Errorf("line %d: environment variable %q is not set", node.Line, name)
+		}
+		return ref
+	})
+	if err != nil || value == node.Value {
+		return nil, err
+	}
+	node.Value = value
+	if node.Style == 0 {
+		// the type of plain scalars is resolved from the expanded value, e.g. a port number
+		node.Tag = ""
+	}
+	if secret {
+		return []string{value}, nil
+	}
+	return nil, nil
+}
+
+// isSecretEnv returns false if the environment variable has been passed with --config.plain-env.
+func isSecretEnv(name string) bool {
+	for _, plainEnv := range plainEnvs {
+		if plainEnv == name {
+			return false
+		}
+	}
+	return true
+}
+
+// redactNode replaces the scalar values of the node that are secrets.
+func redactNode(node *yaml.Node, secrets []string) {
+	if node.Kind == yaml.ScalarNode {
+		for _, secret := range secrets {
+			if len(secret) > 0 && node.Value == secret {
+				node.Value = redactedSecret
+				node.Tag = "!!str"
+				node.Style = 0
+			}
+		}
+		return
+	}
+	for idx, child := range node.Content {
+		// the keys of the mappings are not redacted
+		if node.Kind == yaml.MappingNode && idx%2 == 0 {
+			continue
+		}
+		redactNode(child, secrets)
+	}
+}
+
+// Secrets returns the values of the configuration that came from "*_file" options or referenced secret environment
+// variables.
+func (c *Config) Secrets() []string {
+	return append(append([]string{}, c.secrets...), c.Collects.Secrets()...)
+}
+
+// WriteRedacted writes the configuration in YAML format, with the secrets replaced by "<secret>".
+func (c *Config) WriteRedacted(w io.Writer) error {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return err
+	}
+	redactNode(&node, c.Secrets())
+	return yaml.NewEncoder(w).Encode(&node)
+}
@@ -0,0 +1,21 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "gopkg.in/alecthomas/kingpin.v2"
+
+func AddFlags(flagSet *kingpin.Application) {
+	flagSet.Flag("config.expand-env", "Replace ${VAR} and ${VAR:-default} in the values of the configuration with the environment variables.").Default("false").BoolVar(&expandEnvEnabled)
+	flagSet.Flag("config.plain-env", "The environment variable is not a secret, the values that reference it are shown by verify --config.display instead of <secret>. Can be repeated.").StringsVar(&plainEnvs)
+}
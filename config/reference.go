@@ -0,0 +1,267 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	referenceKey = "ref"
+
+	datasourcesKey     = "datasources"
+	relabelConfigsKey  = "relabel_configs"
+	metricTemplatesKey = "metric_templates"
+)
+
+// configFile is a parsed configuration file, whose references are resolved before it is decoded.
+type configFile struct {
+	name    string
+	node    yaml.Node
+	secrets []string
+}
+
+// wrap adds the file name to the error.
+func (f *configFile) wrap(err error) error {
+	if len(f.name) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %s", f.name, err)
+}
+
+// position returns the file name and line of the node.
+func (f *configFile) position(node *yaml.Node) string {
+	if len(f.name) == 0 {
+		return fmt.Sprintf("line %d", node.Line)
+	}
+	return fmt.Sprintf("%s:%d", f.name, node.Line)
+}
+
+func (f *configFile) errorf(node *yaml.Node, format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", f.position(node), fmt.Sprintf(format, a...))
+}
+
+// definition is a reusable configuration block defined at the top level of a configuration file.
+type definition struct {
+	file *configFile
+	node *yaml.Node
+}
+
+// definitions are the reusable datasources, relabel_configs and metric_templates of all configuration files.
+type definitions struct {
+	datasources     map[string]definition
+	relabelConfigs  map[string]definition
+	metricTemplates map[string]definition
+}
+
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, int) {
+	if node.Kind != yaml.MappingNode {
+		return nil, -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1], i
+		}
+	}
+	return nil, -1
+}
+
+func deleteMappingKey(node *yaml.Node, key string) {
+	if _, idx := mappingValue(node, key); idx >= 0 {
+		node.Content = append(node.Content[:idx], node.Content[idx+2:]...)
+	}
+}
+
+// newDefinitions collects the definitions of the files and removes them from the documents.
+func newDefinitions(files []*configFile) (*definitions, error) {
+	defs := &definitions{
+		datasources:     map[string]definition{},
+		relabelConfigs:  map[string]definition{},
+		metricTemplates: map[string]definition{},
+	}
+	add := func(f *configFile, kind string, m map[string]definition, name *yaml.Node, node *yaml.Node) error {
+		if name == nil || len(name.Value) == 0 {
+			return f.errorf(node, "%s must have a name", kind)
+		}
+		if def, ok := m[name.Value]; ok {
+			return f.errorf(name, "%s %q is already defined at %s", kind, name.Value, def.file.position(def.node))
+		}
+		m[name.Value] = definition{file: f, node: node}
+		return nil
+	}
+	for _, f := range files {
+		if len(f.node.Content) == 0 {
+			continue
+		}
+		doc := f.node.Content[0]
+		for _, kind := range []string{datasourcesKey, metricTemplatesKey} {
+			list, _ := mappingValue(doc, kind)
+			if list == nil {
+				continue
+			} else if list.Kind != yaml.SequenceNode {
+				return nil, f.errorf(list, "%s must be a list", kind)
+			}
+			m := defs.datasources
+			if kind == metricTemplatesKey {
+				m = defs.metricTemplates
+			}
+			for _, item := range list.Content {
+				name, _ := mappingValue(item, "name")
+				if err := add(f, kind, m, name, item); err != nil {
+					return nil, err
+				}
+			}
+			deleteMappingKey(doc, kind)
+		}
+		if chains, _ := mappingValue(doc, relabelConfigsKey); chains != nil {
+			if chains.Kind != yaml.MappingNode {
+				return nil, f.errorf(chains, "%s must be a map of names to relabel_config lists", relabelConfigsKey)
+			}
+			for i := 0; i+1 < len(chains.Content); i += 2 {
+				if chains.Content[i+1].Kind != yaml.SequenceNode {
+					return nil, f.errorf(chains.Content[i+1], "%s %q must be a list", relabelConfigsKey, chains.Content[i].Value)
+				}
+				if err := add(f, relabelConfigsKey, defs.relabelConfigs, chains.Content[i], chains.Content[i+1]); err != nil {
+					return nil, err
+				}
+			}
+			deleteMappingKey(doc, relabelConfigsKey)
+		}
+	}
+	return defs, nil
+}
+
+// copyNode returns a deep copy of the node, so that a definition is not modified when the references are resolved.
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	if len(node.Content) > 0 {
+		copied.Content = make([]*yaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			copied.Content[i] = copyNode(child)
+		}
+	}
+	return &copied
+}
+
+// merge returns a copy of the base mapping, overridden by the keys of the local mapping (except the reference).
+// Nested mappings are merged recursively, other values are replaced.
+func merge(base, local *yaml.Node) *yaml.Node {
+	merged := *base
+	merged.Content = append([]*yaml.Node{}, base.Content...)
+	for i := 0; i+1 < len(local.Content); i += 2 {
+		key, value := local.Content[i], local.Content[i+1]
+		if key.Value == referenceKey {
+			continue
+		}
+		if baseValue, idx := mappingValue(&merged, key.Value); idx < 0 {
+			merged.Content = append(merged.Content, key, value)
+		} else if baseValue.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			merged.Content[idx+1] = merge(baseValue, value)
+		} else {
+			merged.Content[idx+1] = value
+		}
+	}
+	return &merged
+}
+
+// resolveItems replaces the items of the list that reference a definition by the merged definition.
+func (defs *definitions) resolveItems(f *configFile, list *yaml.Node, kind string, m map[string]definition) error {
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for idx, item := range list.Content {
+		ref, _ := mappingValue(item, referenceKey)
+		if ref == nil {
+			continue
+		}
+		def, ok := m[ref.Value]
+		if !ok {
+			return f.errorf(ref, "unknown %s reference %q", kind, ref.Value)
+		}
+		// the relabel_configs references of the definition are resolved in its own file
+		node := copyNode(def.node)
+		if err := defs.resolveRelabelConfigs(def.file, node, 0); err != nil {
+			return err
+		}
+		list.Content[idx] = merge(node, item)
+	}
+	return nil
+}
+
+// resolveRelabelConfigs splices the referenced relabel_configs into every relabel_configs list of the node.
+func (defs *definitions) resolveRelabelConfigs(f *configFile, node *yaml.Node, depth int) error {
+	if depth > 32 {
+		return f.errorf(node, "%s references are nested too deeply", relabelConfigsKey)
+	}
+	if node.Kind == yaml.MappingNode {
+		if list, _ := mappingValue(node, relabelConfigsKey); list != nil && list.Kind == yaml.SequenceNode {
+			var items []*yaml.Node
+			for _, item := range list.Content {
+				ref, _ := mappingValue(item, referenceKey)
+				if ref == nil {
+					items = append(items, item)
+					continue
+				}
+				def, ok := defs.relabelConfigs[ref.Value]
+				if !ok {
+					return f.errorf(ref, "unknown %s reference %q", relabelConfigsKey, ref.Value)
+				}
+				chain := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Value: relabelConfigsKey},
+					copyNode(def.node),
+				}}
+				// the referenced chain may reference other chains
+				if err := defs.resolveRelabelConfigs(def.file, chain, depth+1); err != nil {
+					return err
+				}
+				items = append(items, chain.Content[1].Content...)
+			}
+			resolved := *list
+			resolved.Content = items
+			*list = resolved
+		}
+	}
+	for _, child := range node.Content {
+		if err := defs.resolveRelabelConfigs(f, child, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve replaces the references to datasources, metric_templates and relabel_configs in the collects of the file.
+func (defs *definitions) resolve(f *configFile) error {
+	if len(f.node.Content) == 0 {
+		return nil
+	}
+	collects, _ := mappingValue(f.node.Content[0], "collects")
+	if collects == nil || collects.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, collect := range collects.Content {
+		datasource, _ := mappingValue(collect, "datasource")
+		if err := defs.resolveItems(f, datasource, "datasource", defs.datasources); err != nil {
+			return err
+		}
+		metrics, _ := mappingValue(collect, "metrics")
+		if err := defs.resolveItems(f, metrics, "metric_template", defs.metricTemplates); err != nil {
+			return err
+		}
+		if err := defs.resolveRelabelConfigs(f, collect, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
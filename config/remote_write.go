@@ -0,0 +1,106 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"time"
+)
+
+var (
+	DefaultQueueConfig = QueueConfig{
+		Capacity:          10000,
+		MaxSamplesPerSend: 2000,
+		MaxRetries:        10,
+		MinBackoff:        time.Millisecond * 30,
+		MaxBackoff:        time.Second * 5,
+	}
+	DefaultRemoteWriteConfig = RemoteWriteConfig{
+		Interval:      time.Second * 15,
+		RemoteTimeout: time.Second * 30,
+	}
+)
+
+// QueueConfig is the configuration of the queue of samples waiting to be sent by remote write.
+type QueueConfig struct {
+	// Capacity is the maximum number of samples waiting to be sent, the oldest samples are dropped when it is exceeded.
+	Capacity int `yaml:"capacity,omitempty"`
+	// MaxSamplesPerSend is the maximum number of samples per request.
+	MaxSamplesPerSend int `yaml:"max_samples_per_send,omitempty"`
+	// MaxRetries is the maximum number of retries of a request that failed with a recoverable error.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// MinBackoff is the initial retry delay, it is doubled for every retry.
+	MinBackoff time.Duration `yaml:"min_backoff,omitempty"`
+	// MaxBackoff is the maximum retry delay.
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+type RemoteWriteConfig struct {
+	Name             string                      `yaml:"name,omitempty"`
+	URL              string                      `yaml:"url"`
+	HTTPClientConfig promconfig.HTTPClientConfig `yaml:"http_client_config,inline"`
+	Headers          map[string]string           `yaml:"headers,omitempty"`
+	RemoteTimeout    time.Duration               `yaml:"remote_timeout,omitempty"`
+	// Interval is the interval at which the collects are gathered and pushed.
+	Interval       time.Duration     `yaml:"interval,omitempty"`
+	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+	QueueConfig    QueueConfig       `yaml:"queue_config,omitempty"`
+}
+
+func (c *RemoteWriteConfig) UnmarshalYAML(value *yaml.Node) error {
+	*c = DefaultRemoteWriteConfig
+	c.QueueConfig = DefaultQueueConfig
+	type plain RemoteWriteConfig
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if len(c.URL) == 0 {
+		return fmt.Errorf("url for remote_write is empty")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid remote_write url %q: %s", c.URL, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid remote_write url %q: scheme must be http or https", c.URL)
+	}
+	if len(c.Name) == 0 {
+		c.Name = u.Host + u.Path
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("remote_write interval must be greater than 0: %s", c.Interval)
+	}
+	if c.RemoteTimeout <= 0 {
+		return fmt.Errorf("remote_write remote_timeout must be greater than 0: %s", c.RemoteTimeout)
+	}
+	for name := range c.ExternalLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("%q is not a valid external label name", name)
+		}
+	}
+	q := c.QueueConfig
+	if q.MaxSamplesPerSend <= 0 || q.Capacity < q.MaxSamplesPerSend {
+		return fmt.Errorf("remote_write queue_config capacity (%d) must be greater than or equal to max_samples_per_send (%d), which must be greater than 0", q.Capacity, q.MaxSamplesPerSend)
+	}
+	if q.MaxRetries < 0 {
+		return fmt.Errorf("remote_write queue_config max_retries cannot be negative: %d", q.MaxRetries)
+	}
+	if q.MinBackoff <= 0 || q.MaxBackoff < q.MinBackoff {
+		return fmt.Errorf("remote_write queue_config min_backoff (%s) must be greater than 0 and not greater than max_backoff (%s)", q.MinBackoff, q.MaxBackoff)
+	}
+	return c.HTTPClientConfig.Validate()
+}
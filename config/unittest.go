@@ -0,0 +1,243 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"github.com/MicroOps-cn/data_exporter/collector"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UnitTestFile is a file of unit tests, which check that the collects turn fixed inputs into the expected series.
+type UnitTestFile struct {
+	Tests []*UnitTest `yaml:"tests"`
+}
+
+type UnitTest struct {
+	Name    string           `yaml:"name,omitempty"`
+	Collect string           `yaml:"collect"`
+	Inputs  []*UnitTestInput `yaml:"inputs"`
+	// ExpectedSeries are all the series expected to be collected from the inputs. Histograms and summaries are
+	// flattened into their _bucket/_sum/_count and quantile series.
+	ExpectedSeries []*ExpectedSeries `yaml:"expected_series"`
+}
+
+// UnitTestInput is the data of a datasource of the collect, given inline or by a file path relative to the test file.
+type UnitTestInput struct {
+	Datasource string `yaml:"datasource,omitempty"`
+	Data       string `yaml:"data,omitempty"`
+	File       string `yaml:"file,omitempty"`
+}
+
+func (i *UnitTestInput) UnmarshalYAML(value *yaml.Node) error {
+	type plain UnitTestInput
+	if err := value.Decode((*plain)(i)); err != nil {
+		return err
+	}
+	if (len(i.Data) == 0) == (len(i.File) == 0) {
+		return fmt.Errorf("line %d: exactly one of data and file must be configured", value.Line)
+	}
+	return nil
+}
+
+type ExpectedSeries struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+	Value  float64           `yaml:"value"`
+	// Type is the type of the metric (counter, gauge, histogram, summary or untyped), not checked if empty.
+	Type string `yaml:"type,omitempty"`
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for labelName := range labels {
+		names = append(names, labelName)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, labelName := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labelName, labels[labelName]))
+	}
+	return name + "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func (s *ExpectedSeries) String() string {
+	str := seriesKey(s.Name, s.Labels) + " " + collector.FormatFloat(s.Value)
+	if len(s.Type) > 0 {
+		str += " " + s.Type
+	}
+	return str
+}
+
+// flattenFamilies converts the metric families into series, like in the text exposition format.
+func flattenFamilies(families []*dto.MetricFamily) []*ExpectedSeries {
+	var series []*ExpectedSeries
+	collector.WalkSamples(families, func(family *dto.MetricFamily, metric *dto.Metric, name string, value float64, extra *dto.LabelPair) {
+		labels := map[string]string{}
+		for _, pair := range metric.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		if extra != nil {
+			labels[extra.GetName()] = extra.GetValue()
+		}
+		series = append(series, &ExpectedSeries{Name: name, Labels: labels, Value: value, Type: strings.ToLower(family.GetType().String())})
+	})
+	return series
+}
+
+type fixtureCollector struct {
+	collect *collector.CollectConfig
+	data    map[*collector.Datasource][]byte
+}
+
+func (f *fixtureCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (f *fixtureCollector) Collect(ch chan<- prometheus.Metric) {
+	f.collect.CollectData(f.data, ch)
+}
+
+// run collects the inputs of the test, and returns the differences from the expected series.
+func (t *UnitTest) run(c *Config, dir string) ([]string, error) {
+	var collect *collector.CollectConfig
+	for idx := range c.Collects {
+		if c.Collects[idx].Name == t.Collect {
+			collect = &c.Collects[idx]
+		}
+	}
+	if collect == nil {
+		return nil, fmt.Errorf("collect %q not found", t.Collect)
+	}
+	data := map[*collector.Datasource][]byte{}
+	for _, input := range t.Inputs {
+		var ds *collector.Datasource
+		for _, d := range collect.Datasource {
+			if d.Name == input.Datasource || (len(input.Datasource) == 0 && len(collect.Datasource) == 1) {
+				ds = d
+			}
+		}
+		if ds == nil {
+			return nil, fmt.Errorf("datasource %q not found in collect %q", input.Datasource, t.Collect)
+		} else if _, ok := data[ds]; ok {
+			return nil, fmt.Errorf("datasource %q has more than one input", input.Datasource)
+		}
+		data[ds] = []byte(input.Data)
+		if len(input.File) > 0 {
+			path := input.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			data[ds] = content
+		}
+	}
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(&fixtureCollector{collect: collect, data: data}); err != nil {
+		return nil, err
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	actual := map[string]*ExpectedSeries{}
+	for _, series := range flattenFamilies(families) {
+		actual[seriesKey(series.Name, series.Labels)] = series
+	}
+	var diff []string
+	expected := map[string]bool{}
+	for _, exp := range t.ExpectedSeries {
+		key := seriesKey(exp.Name, exp.Labels)
+		expected[key] = true
+		got, ok := actual[key]
+		if !ok {
+			diff = append(diff, "- "+exp.String())
+			continue
+		}
+		sameValue := got.Value == exp.Value || (math.IsNaN(got.Value) && math.IsNaN(exp.Value))
+		if !sameValue || (len(exp.Type) > 0 && exp.Type != got.Type) {
+			diff = append(diff, "- "+exp.String(), "+ "+got.String())
+		}
+	}
+	var unexpected []string
+	for key, got := range actual {
+		if !expected[key] {
+			unexpected = append(unexpected, "+ "+got.String())
+		}
+	}
+	sort.Strings(unexpected)
+	return append(diff, unexpected...), nil
+}
+
+// LoadUnitTestConfig loads the configuration for the unit tests. The references are resolved like ReloadConfig does,
+// but the configuration is not initialized: the fixtures replace the datasources, so no stream, listener, discovery or
+// scheduled collect is started.
+func LoadUnitTestConfig(confPath string, logger log.Logger) (*Config, error) {
+	c := NewConfig()
+	if err := c.LoadConfig(confPath); err != nil {
+		return nil, err
+	}
+	c.Collects.SetLogger(logger)
+	return c, nil
+}
+
+// RunUnitTests runs the unit tests of the files against the collects of the configuration, and writes the results
+// to w. It returns false if any test failed.
+func RunUnitTests(c *Config, files []string, w io.Writer) bool {
+	success := true
+	for _, file := range files {
+		fmt.Fprintf(w, "Unit testing: %s\n", file)
+		var testFile UnitTestFile
+		content, err := os.ReadFile(file)
+		if err == nil {
+			err = yaml.Unmarshal(content, &testFile)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "  FAILED: %s\n", err)
+			success = false
+			continue
+		}
+		for idx, test := range testFile.Tests {
+			name := test.Name
+			if len(name) == 0 {
+				name = fmt.Sprintf("#%d", idx)
+			}
+			diff, err := test.run(c, filepath.Dir(file))
+			if err != nil {
+				fmt.Fprintf(w, "  FAILED %s: %s\n", name, err)
+				success = false
+			} else if len(diff) > 0 {
+				fmt.Fprintf(w, "  FAILED %s: the collected series differ from the expected series (-expected +collected):\n", name)
+				for _, line := range diff {
+					fmt.Fprintf(w, "    %s\n", line)
+				}
+				success = false
+			} else {
+				fmt.Fprintf(w, "  SUCCESS %s\n", name)
+			}
+		}
+	}
+	return success
+}
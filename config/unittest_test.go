@@ -0,0 +1,119 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	testings "github.com/MicroOps-cn/data_exporter/testings"
+	"github.com/go-kit/log"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunUnitTests(t *testing.T) {
+	tt := testings.NewTesting(t)
+	c := NewConfig()
+	tt.AssertNoError(c.loadConfigFile(io.NopCloser(strings.NewReader(`
+collects:
+  - name: "weather"
+    data_format: "json"
+    datasource:
+      - name: "api"
+        type: "file"
+        url: "weather.json"
+    metrics:
+      - name: "temperature"
+        relabel_configs:
+          - target_label: __name__
+            replacement: "weather_temperature_celsius"
+        match:
+          datapoint: "data"
+          labels:
+            __value__: "temp"
+            city: "city"
+`))))
+	c.Collects.SetLogger(log.NewNopLogger())
+	dir := t.TempDir()
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "weather.json"), []byte(`{"data":[{"city":"a","temp":21.5},{"city":"b","temp":18}]}`), 0644))
+	testFile := filepath.Join(dir, "weather_test.yaml")
+	tests := `
+tests:
+  - name: "expand cities"
+    collect: "weather"
+    inputs:
+      - datasource: "api"
+        file: "weather.json"
+    expected_series:
+      - name: weather_temperature_celsius
+        labels: {city: "a", name: "temperature", temp: "21.5"}
+        value: 21.5
+        type: gauge
+      - name: weather_temperature_celsius
+        labels: {city: "b", name: "temperature", temp: "18"}
+        value: 18
+`
+	tt.AssertNoError(os.WriteFile(testFile, []byte(tests), 0644))
+	var out bytes.Buffer
+	tt.AssertEqual(RunUnitTests(c, []string{testFile}, &out), true, out.String())
+	tt.AssertEqual(strings.Contains(out.String(), "SUCCESS expand cities"), true)
+
+	tt.AssertNoError(os.WriteFile(testFile, []byte(strings.ReplaceAll(tests, "value: 18", "value: 19")), 0644))
+	out.Reset()
+	tt.AssertEqual(RunUnitTests(c, []string{testFile}, &out), false)
+	tt.AssertEqual(strings.Contains(out.String(), `- weather_temperature_celsius{city="b", name="temperature", temp="18"} 19`), true, out.String())
+	tt.AssertEqual(strings.Contains(out.String(), `+ weather_temperature_celsius{city="b", name="temperature", temp="18"} 18 gauge`), true, out.String())
+}
+
+func TestRunUnitTestsUnreachableStream(t *testing.T) {
+	tt := testings.NewTesting(t)
+	dir := t.TempDir()
+	confFile := filepath.Join(dir, "data_exporter.yaml")
+	tt.AssertNoError(os.WriteFile(confFile, []byte(`
+collects:
+  - name: "events"
+    data_format: "regex"
+    datasource:
+      - name: "stream"
+        type: "tcp"
+        url: "127.0.0.1:1"
+        read_mode: "stream"
+    metrics:
+      - name: "event_value"
+        match:
+          datapoint: '^(?P<host>\S+) (?P<value>\S+)$'
+          labels:
+            __value__: "value"
+            host: "host"
+`), 0644))
+	c, err := LoadUnitTestConfig(confFile, log.NewNopLogger())
+	tt.AssertNoError(err)
+	testFile := filepath.Join(dir, "events_test.yaml")
+	tt.AssertNoError(os.WriteFile(testFile, []byte(`
+tests:
+  - name: "unreachable stream"
+    collect: "events"
+    inputs:
+      - data: "a 1\n"
+    expected_series:
+      - name: event_value
+        labels: {host: "a", value: "1"}
+        value: 1
+`), 0644))
+	var out bytes.Buffer
+	tt.AssertEqual(RunUnitTests(c, []string{testFile}, &out), true, out.String())
+	tt.AssertEqual(strings.Contains(out.String(), "SUCCESS unreachable stream"), true)
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/fsnotify.v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WatchDebounce is the time to wait for more changes before the configuration is reloaded.
+var WatchDebounce = time.Second
+
+// isConfigEvent returns whether the event may change the configuration loaded from confPath.
+func isConfigEvent(event fsnotify.Event, confPath string, isDir bool) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	if isDir {
+		return true
+	}
+	// Kubernetes ConfigMap volumes replace the files by swapping the "..data" symlink.
+	return filepath.Clean(event.Name) == filepath.Clean(confPath) || strings.HasPrefix(filepath.Base(event.Name), "..")
+}
+
+// Watch calls reload when the configuration files in confPath change, until the context is done. The directory of
+// the configuration is watched instead of the files, so that the files replaced by rename or symlink swap (like
+// Kubernetes ConfigMap volumes) are detected. Bursts of changes are debounced into a single reload.
+func Watch(ctx context.Context, confPath string, logger log.Logger, reload func()) error {
+	stat, err := os.Stat(confPath)
+	if err != nil {
+		return err
+	}
+	watchDir := confPath
+	if !stat.IsDir() {
+		watchDir = filepath.Dir(confPath)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err = watcher.Add(watchDir); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		debounce := time.NewTimer(WatchDebounce)
+		debounce.Stop()
+		defer debounce.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				if isConfigEvent(event, confPath, stat.IsDir()) {
+					level.Debug(logger).Log("msg", "config file changed", "file", event.Name, "op", event.Op)
+					debounce.Reset(WatchDebounce)
+				}
+			case err := <-watcher.Errors:
+				level.Error(logger).Log("msg", "error watching config path", "err", err)
+			case <-debounce.C:
+				level.Info(logger).Log("msg", "Reload config from file change")
+				reload()
+			}
+		}
+	}()
+	return nil
+}
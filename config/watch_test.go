@@ -0,0 +1,60 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	testings "github.com/MicroOps-cn/data_exporter/testings"
+	"github.com/go-kit/log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	tt := testings.NewTesting(t)
+	debounce := WatchDebounce
+	t.Cleanup(func() { WatchDebounce = debounce })
+	WatchDebounce = time.Millisecond * 100
+	dir := t.TempDir()
+	// the layout of a Kubernetes ConfigMap volume
+	swap := func(version string) {
+		tt.AssertNoError(os.Mkdir(filepath.Join(dir, version), 0755))
+		tt.AssertNoError(os.WriteFile(filepath.Join(dir, version, "data_exporter.yaml"), []byte("collects: []\n"), 0644))
+		tt.AssertNoError(os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+		tt.AssertNoError(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	swap("..v1")
+	confPath := filepath.Join(dir, "data_exporter.yaml")
+	tt.AssertNoError(os.Symlink(filepath.Join("..data", "data_exporter.yaml"), confPath))
+
+	var reloads int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tt.AssertNoError(Watch(ctx, confPath, log.NewNopLogger(), func() {
+		atomic.AddInt32(&reloads, 1)
+	}))
+
+	swap("..v2")
+	tt.AssertNoError(os.RemoveAll(filepath.Join(dir, "..v1")))
+	time.Sleep(time.Millisecond * 500)
+	// the burst of changes is reloaded once
+	tt.AssertEqual(atomic.LoadInt32(&reloads), int32(1))
+
+	tt.AssertNoError(os.WriteFile(filepath.Join(dir, "other.txt"), []byte("other"), 0644))
+	time.Sleep(time.Millisecond * 300)
+	tt.AssertEqual(atomic.LoadInt32(&reloads), int32(1))
+}
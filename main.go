@@ -14,6 +14,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/MicroOps-cn/data_exporter/collector"
 	"github.com/MicroOps-cn/data_exporter/config"
@@ -28,30 +29,40 @@ import (
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
-	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	stdlog "log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
 
 var (
-	sc            = config.NewSafeConfig()
-	flagSet       = kingpin.New(os.Args[0], "Prometheus Common Data Exporter is used to parse JSON, XML, yaml or other format data from multiple sources (such as HTTP response message, local file, TCP response message and UDP response message) into Prometheus metric data.")
-	exporterName  = collector.ExporterName
-	debugFlagSet  = flagSet.Command("debug", "Debug configuration")
-	runFlagSet    = flagSet.Command("run", "Run a exporter")
-	verifyFlagSet = flagSet.Command("verify", "verify configuration")
+	sc              = config.NewSafeConfig()
+	flagSet         = kingpin.New(os.Args[0], "Prometheus Common Data Exporter is used to parse JSON, XML, yaml or other format data from multiple sources (such as HTTP response message, local file, TCP response message and UDP response message) into Prometheus metric data.")
+	exporterName    = collector.ExporterName
+	debugFlagSet    = flagSet.Command("debug", "Debug configuration")
+	runFlagSet      = flagSet.Command("run", "Run a exporter")
+	verifyFlagSet   = flagSet.Command("verify", "verify configuration")
+	testFlagSet     = flagSet.Command("test", "Run unit tests of the configuration against fixed inputs")
+	generateFlagSet = flagSet.Command("generate", "Generate a starter configuration from sample data")
 
-	displayConfig = verifyFlagSet.Flag("config.display", "display configuration").Bool()
+	displayConfig      = verifyFlagSet.Flag("config.display", "display configuration").Bool()
+	traceCollects      = debugFlagSet.Flag("trace-collect", "Trace the relabel steps of every series of the collect, can be repeated.").Strings()
+	testFiles          = testFlagSet.Arg("test-file", "The unit test files.").Required().ExistingFiles()
+	generateSample     = generateFlagSet.Arg("sample", "The sample data file.").Required().ExistingFile()
+	generateDataFormat = generateFlagSet.Flag("data-format", "The data format of the sample: json, yaml or xml. Defaults to the extension of the sample file.").String()
+	generateName       = generateFlagSet.Flag("name", "The name of the collect. Defaults to the name of the sample file.").String()
+	generateUrl        = generateFlagSet.Flag("url", "The url of the datasource. Defaults to the path of the sample file.").String()
 
 	// Deprecated
-	configFile = flagSet.Flag("config.file", "[Deprecated]Blackbox exporter configuration file.").String()
-	configPath = flagSet.Flag("config.path", "Blackbox exporter configuration path. can be a directory").Default(exporterName + ".yaml").String()
+	configFile  = flagSet.Flag("config.file", "[Deprecated]Blackbox exporter configuration file.").String()
+	configPath  = flagSet.Flag("config.path", "Blackbox exporter configuration path. can be a directory").Default(exporterName + ".yaml").String()
+	configWatch = flagSet.Flag("config.watch", "Reload the configuration automatically when the files in config.path change.").Bool()
 
 	promlogConfig = &logs.Config{}
 	rootLogger    log.Logger
@@ -66,6 +77,7 @@ func init() {
 	flagSet.Version(version.Print(exporterName))
 	flagSet.HelpFlag.Short('h')
 	collector.AddFlags(flagSet)
+	config.AddFlags(flagSet)
 	transport.AddFlags(flagSet, runFlagSet)
 
 	flagSet.PreAction(func(pCtx *kingpin.ParseContext) error {
@@ -74,10 +86,25 @@ func init() {
 				return nil
 			}
 		}
+		if pCtx.SelectedCommand == generateFlagSet {
+			// generate does not need a configuration
+			return nil
+		}
 		rootLogger = logs.New(promlogConfig)
 		if len(*configFile) > 0 {
 			*configPath = *configFile
 		}
+		if pCtx.SelectedCommand == testFlagSet {
+			// the fixtures replace the datasources, so the commands of the exec datasources are never run
+			collector.EnableExec()
+			c, err := config.LoadUnitTestConfig(*configPath, rootLogger)
+			if err != nil {
+				level.Error(rootLogger).Log("msg", "Error loading config", "err", err, "configPath", configPath)
+				return err
+			}
+			sc.SetConfig(c)
+			return nil
+		}
 		if err := sc.ReloadConfig(*configPath, rootLogger); err != nil {
 			level.Error(rootLogger).Log("msg", "Error loading config", "err", err, "configPath", configPath)
 			return err
@@ -99,6 +126,17 @@ func init() {
 			return err
 		}
 		debugLogger := logs.New(&debugLogCfg)
+		for _, name := range *traceCollects {
+			collect := sc.GetConfig().Collects.Get(name)
+			if collect == nil {
+				return fmt.Errorf("unknown collect: %s", name)
+			}
+			collect.SetLogger(debugLogger)
+			term.Title("Relabel Trace: "+name, '=')
+			if err := collect.DryRun(context.Background(), nil).WriteTrace(os.Stdout); err != nil {
+				return err
+			}
+		}
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			conf := sc.GetConfig()
 			time.Sleep(time.Second / 2)
@@ -133,10 +171,40 @@ func init() {
 		return nil
 	})
 
+	generateFlagSet.Action(func(_ *kingpin.ParseContext) error {
+		data, err := os.ReadFile(*generateSample)
+		if err != nil {
+			return err
+		}
+		ext := strings.TrimPrefix(filepath.Ext(*generateSample), ".")
+		if len(*generateDataFormat) == 0 {
+			*generateDataFormat = strings.Replace(ext, "yml", "yaml", 1)
+		}
+		if len(*generateName) == 0 {
+			*generateName = strings.TrimSuffix(filepath.Base(*generateSample), filepath.Ext(*generateSample))
+		}
+		if len(*generateUrl) == 0 {
+			*generateUrl = *generateSample
+		}
+		generated, err := collector.GenerateConfig(*generateName, collector.DataFormat(*generateDataFormat), *generateUrl, data)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(generated)
+		return err
+	})
+
+	testFlagSet.Action(func(_ *kingpin.ParseContext) error {
+		if !config.RunUnitTests(sc.GetConfig(), *testFiles, os.Stdout) {
+			return fmt.Errorf("unit tests failed")
+		}
+		return nil
+	})
+
 	verifyFlagSet.Action(func(_ *kingpin.ParseContext) error {
 		level.Info(rootLogger).Log("msg", "Config file is ok, exiting...")
 		if *displayConfig {
-			_ = yaml.NewEncoder(os.Stdout).Encode(sc.GetConfig())
+			_ = sc.GetConfig().WriteRedacted(os.Stdout)
 		}
 		return nil
 	})
@@ -172,6 +240,25 @@ func run(logger log.Logger) error {
 		}
 	}()
 
+	if *configWatch {
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		if err := config.Watch(watchCtx, *configPath, logger, func() {
+			rc := make(chan error)
+			reloadCh <- rc
+			<-rc
+		}); err != nil {
+			return fmt.Errorf("failed to watch config path: %s", err)
+		}
+	}
+
+	stopPositionsSync := collector.StartPositionsSync(logger)
+	defer stopPositionsSync()
+
+	remoteWriteCtx, stopRemoteWrite := context.WithCancel(context.Background())
+	defer stopRemoteWrite()
+	go transport.NewRemoteWriter(logger, sc).Run(remoteWriteCtx)
+
 	serve, err := transport.NewHttpServer(logger, sc)
 
 	serve.HandleFunc("/-/reload",
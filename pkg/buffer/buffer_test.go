@@ -17,7 +17,9 @@ import (
 	"github.com/MicroOps-cn/data_exporter/testings"
 	"io"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewLineBuffer(t *testing.T) {
@@ -60,3 +62,36 @@ func TestNewLineBuffer(t *testing.T) {
 		}
 	}
 }
+
+func TestEventBuffer(t *testing.T) {
+	tt := testings.NewTesting(t)
+	stream := ": keepalive\n" +
+		"retry: 3000\n" +
+		"id: 1\n" +
+		"event: update\n" +
+		"data: {\"a\":1}\n\n" +
+		"id: 2\r\n" +
+		"data:{\"b\":\r\n" +
+		"data: 2}\r\n\r\n" +
+		"id: 3\n\n" +
+		"data: incomplete\n"
+	buf := NewEventBuffer(io.NopCloser(strings.NewReader(stream)), 0, 16)
+	line, err := buf.ReadLine()
+	tt.AssertNoError(err)
+	tt.AssertEqual(`{"a":1}`, string(line))
+	tt.AssertEqual("1", buf.LastEventID())
+	tt.AssertEqual(time.Second*3, buf.Retry())
+	line, err = buf.ReadLine()
+	tt.AssertNoError(err)
+	tt.AssertEqual("{\"b\":\n2}", string(line))
+	tt.AssertEqual("2", buf.LastEventID())
+	// the id of an event without data is kept, the event that is not terminated is dropped
+	_, err = buf.ReadLine()
+	tt.AssertEqual(io.EOF, err)
+	tt.AssertEqual("3", buf.LastEventID())
+
+	buf = NewEventBuffer(io.NopCloser(strings.NewReader("data: 0123456789\ndata: 0123456789\n\n")), 0, 16)
+	_, err = buf.ReadLine()
+	tt.AssertNotEqual(nil, err)
+	tt.AssertNotEqual(io.EOF, err)
+}
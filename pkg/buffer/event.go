@@ -0,0 +1,135 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// EventBuffer reads the events of a Server-Sent Events stream (text/event-stream). ReadLine returns the data of the
+// next event, the data of an event with several data fields is joined by "\n".
+type EventBuffer struct {
+	io.Closer
+	buf         *bufio.Scanner
+	maxData     int
+	id          string
+	lastEventID string
+	retry       time.Duration
+}
+
+var _ ReadLineCloser = &EventBuffer{}
+
+// NewEventBuffer returns the EventBuffer of the stream. maxRead limits the size of the stream and maxData the size of
+// the data of an event, they are unlimited if 0.
+func NewEventBuffer(rc io.ReadCloser, maxRead int64, maxData int) *EventBuffer {
+	buf := &EventBuffer{Closer: rc, maxData: maxData}
+	if maxRead > 0 {
+		buf.buf = bufio.NewScanner(io.LimitReader(rc, maxRead))
+	} else {
+		buf.buf = bufio.NewScanner(rc)
+	}
+	if maxData > 0 {
+		buf.buf.Buffer(make([]byte, 0, 4096), maxData+len("data: \r\n"))
+	} else {
+		buf.buf.Buffer(make([]byte, 0, 4096), bufio.MaxScanTokenSize*1024)
+	}
+	buf.buf.Split(scanEventLines)
+	return buf
+}
+
+// scanEventLines splits the lines of the stream, terminated by CRLF, LF or CR.
+func scanEventLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		} else if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		} else if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// the next byte may be the LF of CRLF
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (r *EventBuffer) ReadLine() ([]byte, error) {
+	var data []byte
+	hasData := false
+	for r.buf.Scan() {
+		line := r.buf.Bytes()
+		if len(line) == 0 {
+			// dispatch the event
+			r.lastEventID = r.id
+			if hasData {
+				return data, nil
+			}
+			continue
+		} else if line[0] == ':' {
+			// comment, usually sent as a keepalive
+			continue
+		}
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+		}
+		switch string(field) {
+		case "data":
+			if hasData {
+				data = append(data, '\n')
+			}
+			data, hasData = append(data, value...), true
+			if r.maxData > 0 && len(data) > r.maxData {
+				return nil, fmt.Errorf("event data is too long: more than %d bytes", r.maxData)
+			}
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				r.id = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 32); err == nil {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := r.buf.Err(); err != nil {
+		return nil, err
+	}
+	// an event that is not terminated by a blank line is discarded
+	return nil, io.EOF
+}
+
+// LastEventID returns the id of the last event read, which is sent back in the Last-Event-ID header when reconnecting.
+func (r *EventBuffer) LastEventID() string {
+	return r.lastEventID
+}
+
+// Retry returns the reconnection time sent by the server, 0 if it is not sent.
+func (r *EventBuffer) Retry() time.Duration {
+	return r.retry
+}
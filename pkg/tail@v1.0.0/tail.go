@@ -30,11 +30,16 @@ type Line struct {
 	Text string
 	Time time.Time
 	Err  error // Error from tail
+
+	// Offset is the position in the file following the line.
+	Offset int64
+	// FileInfo describes the file the line was read from.
+	FileInfo os.FileInfo
 }
 
 // NewLine returns a Line with present time.
 func NewLine(text string) *Line {
-	return &Line{text, time.Now(), nil}
+	return &Line{Text: text, Time: time.Now()}
 }
 
 // SeekInfo represents arguments to `os.Seek`
@@ -87,6 +92,13 @@ type Tail struct {
 	watcher watch.FileWatcher
 	changes *watch.FileChanges
 
+	// offset is the position following the last scanned line, advance is the size of the last token.
+	offset   int64
+	advance  int
+	fileInfo os.FileInfo
+	// position of the last line returned by ReadLine
+	lastLine *Line
+
 	tomb.Tomb // provides: Done, Kill, Dying
 
 	lk sync.Mutex
@@ -102,11 +114,21 @@ func (tail *Tail) ReadLine() ([]byte, error) {
 		if line.Err != nil {
 			return nil, line.Err
 		}
+		tail.lastLine = line
 		return []byte(line.Text), nil
 	}
 	return nil, io.EOF
 }
 
+// Position returns the file info and the offset following the last line returned by ReadLine.
+// The file info is nil if no line has been read.
+func (tail *Tail) Position() (os.FileInfo, int64) {
+	if tail.lastLine == nil {
+		return nil, 0
+	}
+	return tail.lastLine.FileInfo, tail.lastLine.Offset
+}
+
 var (
 	// DefaultLogger is used when Config.Logger == nil
 	DefaultLogger = log.New(os.Stderr, "", log.LstdFlags)
@@ -232,6 +254,7 @@ func (tail *Tail) readLine() (string, error) {
 		return string(tail.scanner.Bytes()), io.EOF
 	}
 	line := string(tail.scanner.Bytes())
+	tail.offset += int64(tail.advance)
 	tail.lk.Unlock()
 	line = strings.TrimRight(line, "\n")
 	return line, tail.scanner.Err()
@@ -290,7 +313,7 @@ func (tail *Tail) tailFileSync() {
 				msg := fmt.Sprintf(
 					"Too much log activity; waiting a second " +
 						"before resuming tailing")
-				tail.Lines <- &Line{msg, time.Now(), fmt.Errorf(msg)}
+				tail.Lines <- &Line{Text: msg, Time: time.Now(), Err: fmt.Errorf(msg)}
 				select {
 				case <-time.After(time.Second):
 				case <-tail.Dying():
@@ -395,6 +418,8 @@ func (tail *Tail) waitForChanges() error {
 }
 
 func (tail *Tail) openReader() {
+	tail.offset, _ = tail.file.Seek(0, io.SeekCurrent)
+	tail.fileInfo, _ = tail.file.Stat()
 	if tail.MaxLineSize > 0 {
 		// add 2 to account for newline characters
 		tail.reader = bufio.NewReaderSize(tail.file, tail.MaxLineSize+2)
@@ -404,6 +429,11 @@ func (tail *Tail) openReader() {
 	tail.scanner = NewScanner(tail.reader)
 	//if len(tail.LineSep) > 1 || (len(tail.LineSep) == 1 && tail.LineSep[0] != "\n") {
 	tail.scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		defer func() {
+			if token != nil {
+				tail.advance = advance
+			}
+		}()
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
 		}
@@ -444,10 +474,11 @@ func (tail *Tail) seekEnd() error {
 }
 
 func (tail *Tail) seekTo(pos SeekInfo) error {
-	_, err := tail.file.Seek(pos.Offset, pos.Whence)
+	offset, err := tail.file.Seek(pos.Offset, pos.Whence)
 	if err != nil {
 		return fmt.Errorf("Seek error on %s: %s", tail.Filename, err)
 	}
+	tail.offset = offset
 	// Reset the read buffer whenever the file is re-seek'ed
 	tail.reader.Reset(tail.file)
 
@@ -465,7 +496,7 @@ func (tail *Tail) sendLine(line string) bool {
 		lines = util.PartitionString(line, tail.MaxLineSize)
 	}
 	for _, line := range lines {
-		tail.Lines <- &Line{line, now, nil}
+		tail.Lines <- &Line{Text: line, Time: now, Offset: tail.offset, FileInfo: tail.fileInfo}
 	}
 
 	if tail.Config.RateLimiter != nil {
@@ -185,6 +185,9 @@ func NewHttpServer(logger log.Logger, sc *config.SafeConfig) (*HttpServer, error
 		serve.HandleFunc(path.Join(serve.pattern(serve.uiPrefix), "api/load/data"), func(writer http.ResponseWriter, request *http.Request) {
 			serve.loadData(logger, writer, request)
 		})
+		serve.HandleFunc(path.Join(serve.pattern(serve.uiPrefix), "api/collect"), func(writer http.ResponseWriter, request *http.Request) {
+			serve.dryRunCollect(logger, writer, request)
+		})
 	}
 	serve.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if serve.uiPrefix != "/" && strings.HasPrefix(r.URL.Path, serve.uiPrefix) {
@@ -198,6 +201,10 @@ func NewHttpServer(logger log.Logger, sc *config.SafeConfig) (*HttpServer, error
 				return
 			}
 		} else {
+			if strings.HasSuffix(r.URL.Path, "/push") && r.URL.Path != "/push" {
+				serve.pushData(logger, strings.TrimSuffix(strings.Trim(r.URL.Path, "/"), "/push"), w, r)
+				return
+			}
 			if strings.HasSuffix(r.URL.Path, "/metrics") {
 				if r.URL.Path == "/metrics" {
 					serve.collectMetrics(logger, w, r)
@@ -284,6 +291,7 @@ func (s *HttpServer) collectMetrics(logger log.Logger, w http.ResponseWriter, r
 	)
 	config.RegisterCollector(reg)
 	collector.RegisterCollector(reg)
+	RegisterCollector(reg)
 	handler := promhttp.HandlerFor(
 		prometheus.Gatherers{reg},
 		promhttp.HandlerOpts{
@@ -296,11 +304,47 @@ func (s *HttpServer) collectMetrics(logger log.Logger, w http.ResponseWriter, r
 	handler.ServeHTTP(w, r)
 }
 
+// pushData updates the series of the collect with the pushed body, in the data_format of the collect.
+func (s *HttpServer) pushData(logger log.Logger, name string, w http.ResponseWriter, r *http.Request) {
+	collect := s.safeConfig.GetConfig().Collects.Get(name)
+	if collect == nil {
+		http.NotFound(w, r)
+		return
+	} else if collect.Push == nil {
+		http.Error(w, fmt.Sprintf("push is not enabled for collect %s", name), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(r.Body, collect.Push.MaxContentLength+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if int64(len(data)) > collect.Push.MaxContentLength {
+		http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	logger = log.With(logger, "collect", name)
+	samples, err := collect.PushData(logger, data)
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to push data", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level.Debug(logger).Log("msg", "data pushed", "samples", samples)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type GetDatapointsRequest struct {
 	Data       string               `json:"data"`
 	Rule       string               `json:"rule"`
 	Mode       collector.DataFormat `json:"mode"`
 	LabelMatch map[string]string    `json:"label_match"`
+	CSV        *collector.CSVConfig `json:"csv,omitempty"`
 }
 
 func (s *HttpServer) error(logger log.Logger, w http.ResponseWriter, err error) {
@@ -345,6 +389,24 @@ func (s *HttpServer) getDatapoints(logger log.Logger, w http.ResponseWriter, r *
 		dps = mc.GetDatapointsByJson(logger, []byte(req.Data))
 	case collector.Yaml:
 		dps = mc.GetDatapointsByYaml(logger, []byte(req.Data))
+	case collector.Prometheus:
+		if err := mc.BuildPrometheusMatch(""); err != nil {
+			s.error(logger, w, err)
+			return
+		}
+		dps = mc.GetDatapointsByPrometheus(logger, []byte(req.Data))
+	case collector.Csv, collector.Tsv:
+		if req.CSV == nil {
+			req.CSV = &collector.CSVConfig{}
+		}
+		if req.Mode == collector.Tsv && len(req.CSV.Delimiter) == 0 {
+			req.CSV.Delimiter = "\t"
+		}
+		if err := req.CSV.Verify(); err != nil {
+			s.error(logger, w, err)
+			return
+		}
+		dps = mc.GetDatapointsByCsv(logger, []byte(req.Data), req.CSV)
 	}
 	_ = json.NewEncoder(w).Encode(dps)
 }
@@ -361,16 +423,20 @@ func (s *HttpServer) getMetrics(logger log.Logger, w http.ResponseWriter, r *htt
 		return
 	}
 
+	metricType := req.MetricConfig.MetricType
+	if len(metricType) == 0 {
+		metricType = collector.Gauge
+	}
 	mgs := collector.NewMetricGenerators(10, logger)
 	go func() {
 		var err error
 		for _, dp := range req.Datapoints {
-			m := collector.NewMetricGenerator(logger, "", collector.Gauge)
+			m := collector.NewMetricGenerator(logger, "", metricType)
 			for name, val := range dp {
 				m.Labels.Append(name, val)
 			}
 			m.Labels, err = req.MetricConfig.Relabels(logger, req.RelabelConfigs, m.Labels)
-			if err != nil {
+			if err != nil || m.Labels == nil {
 				continue
 			}
 
@@ -406,6 +472,11 @@ func (s *HttpServer) loadData(logger log.Logger, w http.ResponseWriter, r *http.
 		s.error(logger, w, err)
 		return
 	}
+	if ds.Type == collector.Exec {
+		// the commands of the UI would run on the exporter host
+		http.Error(w, "exec datasource is not allowed", http.StatusForbidden)
+		return
+	}
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancelFunc()
 	var line []byte
@@ -433,3 +504,34 @@ func (s *HttpServer) loadData(logger log.Logger, w http.ResponseWriter, r *http.
 	}
 	_, _ = w.Write(line)
 }
+
+type DryRunCollectRequest struct {
+	// Config is the collect configuration in yaml.
+	Config string `json:"config"`
+	// Data maps the names of datasources to inline data, the other datasources are read.
+	Data map[string]string `json:"data"`
+}
+
+func (s *HttpServer) dryRunCollect(logger log.Logger, w http.ResponseWriter, r *http.Request) {
+	var req DryRunCollectRequest
+	if err := s.decodeRequest(logger, &req, w, r); err != nil {
+		return
+	}
+	var c collector.CollectConfig
+	if err := yaml.Unmarshal([]byte(req.Config), &c); err != nil {
+		http.Error(w, fmt.Sprintf("invalid collect config: %s", err), http.StatusBadRequest)
+		return
+	}
+	for _, ds := range c.Datasource {
+		if ds.Type == collector.Exec {
+			// the commands of the UI would run on the exporter host, even if the data is inline
+			http.Error(w, "exec datasource is not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	c.SetLogger(logger)
+	ctx, cancelFunc := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancelFunc()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.DryRun(ctx, req.Data))
+}
@@ -15,11 +15,13 @@ package transport
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/MicroOps-cn/data_exporter/collector"
 	"github.com/MicroOps-cn/data_exporter/config"
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v3"
 	"io"
 	"math/rand"
@@ -27,6 +29,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -413,3 +416,215 @@ func TestHTTPRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestDryRunCollect(t *testing.T) {
+	logger := log.NewLogfmtLogger(os.Stdout)
+	server, err := NewHttpServer(logger, config.NewSafeConfig())
+	require.NoError(t, err)
+	body, err := json.Marshal(DryRunCollectRequest{
+		Config: `
+name: dry-run
+data_format: json
+datasource:
+  - name: inline
+    type: file
+    url: /not/exists.json
+    relabel_configs:
+      - target_label: source
+        replacement: inline
+metrics:
+  - name: memory
+    metric_type: counter
+    match:
+      datapoint: "data"
+      labels:
+        __value__: memory
+    relabel_configs:
+      - source_labels: [name]
+        regex: drop.*
+        action: drop
+`,
+		Data: map[string]string{"inline": `{"data":[{"name":"server1","memory":1},{"name":"drop1","memory":2}]}`},
+	})
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	server.dryRunCollect(logger, rr, httptest.NewRequest("POST", "/api/collect", bytes.NewReader(body)))
+	require.Equal(t, 200, rr.Code)
+	var result collector.DryRunResult
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+	require.Empty(t, result.Errors)
+	require.Len(t, result.Inputs, 1)
+	require.Len(t, result.Inputs[0].Metrics, 1)
+	metric := result.Inputs[0].Metrics[0]
+	require.Equal(t, collector.Counter, metric.MetricType)
+	require.Len(t, metric.Series, 2)
+	for _, series := range metric.Series {
+		require.Len(t, series.RelabelSteps, 2)
+		require.Equal(t, "datasource[0].relabel_configs[0]", series.RelabelSteps[0].Source)
+		require.Equal(t, "metrics[0].relabel_configs[0]", series.RelabelSteps[1].Source)
+		if series.Labels.Get("name") == "drop1" {
+			require.True(t, series.RelabelSteps[1].Dropped)
+			require.Empty(t, series.FinalLabels)
+		} else {
+			require.Equal(t, "inline", series.FinalLabels.Get("source"))
+		}
+	}
+	require.Contains(t, metric.Exposition, "# TYPE memory counter")
+	require.Contains(t, result.Exposition, `memory{memory="1",name="server1",source="inline"} 1`)
+	require.NotContains(t, result.Exposition, "drop1")
+
+	rr = httptest.NewRecorder()
+	body, err = json.Marshal(DryRunCollectRequest{Config: "name: [invalid"})
+	require.NoError(t, err)
+	server.dryRunCollect(logger, rr, httptest.NewRequest("POST", "/api/collect", bytes.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestPushData(t *testing.T) {
+	*routePrefix, *externalURL = "", ""
+	sc := config.NewSafeConfig()
+	logger := log.NewLogfmtLogger(os.Stdout)
+	require.NoError(t, sc.ReloadConfigFromReader(io.NopCloser(strings.NewReader(`
+collects:
+- name: "batch"
+  data_format: "csv"
+  csv:
+    header: true
+  push:
+    ttl: 200ms
+  metrics:
+    - name: "job_duration_seconds"
+      match:
+        labels:
+          __value__: duration
+          job: job
+- name: "test-http"
+  data_format: "json"
+  datasource:
+    - type: "file"
+      url: "examples/my_data.json"
+`)), logger))
+	server, err := NewHttpServer(logger, sc)
+	require.NoError(t, err)
+	push := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+	metrics := func() string {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", "/batch/metrics", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	require.Equal(t, http.StatusNoContent, push("POST", "/batch/push", "job,duration\nbackup,12.5\nreport,3\n").Code)
+	body := metrics()
+	require.Contains(t, body, `job_duration_seconds{duration="12.5",job="backup"} 12.5`)
+	require.Contains(t, body, `job_duration_seconds{duration="3",job="report"} 3`)
+
+	require.Equal(t, http.StatusBadRequest, push("POST", "/batch/push", "job,duration\nbackup,x\n").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, push("GET", "/batch/push", "").Code)
+	require.Equal(t, http.StatusNotFound, push("POST", "/test-http/push", "{}").Code)
+	require.Equal(t, http.StatusNotFound, push("POST", "/unknown/push", "{}").Code)
+
+	// the pushed series expire after the ttl
+	time.Sleep(time.Millisecond * 300)
+	require.NotContains(t, metrics(), "job_duration_seconds")
+}
+
+func TestPushDataReload(t *testing.T) {
+	*routePrefix, *externalURL = "", ""
+	sc := config.NewSafeConfig()
+	logger := log.NewLogfmtLogger(os.Stdout)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(help string) {
+		require.NoError(t, os.WriteFile(configPath, []byte(`
+collects:
+- name: "batch"
+  data_format: "csv"
+  csv:
+    header: true
+  push: {}
+  metrics:
+    - name: "job_duration_seconds"
+      help: "`+help+`"
+      match:
+        labels:
+          __value__: duration
+          job: job
+`), 0644))
+		require.NoError(t, sc.ReloadConfig(configPath, logger))
+	}
+	writeConfig("duration of the job")
+	server, err := NewHttpServer(logger, sc)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("POST", "/batch/push", strings.NewReader("job,duration\nbackup,12.5\n")))
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	// the pushed series are kept by the collect of the reloaded configuration
+	writeConfig("duration of the last run of the job")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/batch/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `job_duration_seconds{duration="12.5",job="backup"} 12.5`)
+}
+
+// enableExec enables the exec datasources as --collector.exec.enable does, so that the APIs are the ones refusing them.
+func enableExec(t *testing.T) {
+	parseCollectorFlags := func(args ...string) {
+		app := kingpin.New("test", "")
+		collector.AddFlags(app)
+		_, err := app.Parse(args)
+		require.NoError(t, err)
+	}
+	parseCollectorFlags("--collector.exec.enable")
+	t.Cleanup(func() { parseCollectorFlags() })
+}
+
+func TestLoadDataExec(t *testing.T) {
+	enableExec(t)
+	logger := log.NewLogfmtLogger(os.Stdout)
+	server, err := NewHttpServer(logger, config.NewSafeConfig())
+	require.NoError(t, err)
+	marker := filepath.Join(t.TempDir(), "ran")
+	rr := httptest.NewRecorder()
+	server.loadData(logger, rr, httptest.NewRequest("POST", "/api/load/data", strings.NewReader(`
+type: exec
+url: sh
+config:
+  args: ["-c", "touch `+marker+`"]
+`)))
+	// the datasource is decoded since exec is enabled, it is refused by loadData
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Contains(t, rr.Body.String(), "exec datasource is not allowed")
+	require.NoFileExists(t, marker)
+}
+
+func TestDryRunCollectExec(t *testing.T) {
+	enableExec(t)
+	logger := log.NewLogfmtLogger(os.Stdout)
+	server, err := NewHttpServer(logger, config.NewSafeConfig())
+	require.NoError(t, err)
+	marker := filepath.Join(t.TempDir(), "ran")
+	body, err := json.Marshal(DryRunCollectRequest{Config: `
+name: dry-run-exec
+data_format: json
+datasource:
+  - name: exec
+    type: exec
+    url: sh
+    config:
+      args: ["-c", "touch ` + marker + `"]
+metrics:
+  - name: memory
+    match:
+      datapoint: "data"
+`})
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	server.dryRunCollect(logger, rr, httptest.NewRequest("POST", "/api/collect", bytes.NewReader(body)))
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.NoFileExists(t, marker)
+}
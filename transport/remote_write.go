@@ -0,0 +1,337 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/MicroOps-cn/data_exporter/collector"
+	"github.com/MicroOps-cn/data_exporter/config"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	remoteWriteSamplesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: collector.ExporterName,
+		Name:      "remote_write_samples_sent_total",
+		Help:      "number of samples successfully sent by remote write",
+	}, []string{"remote"})
+	remoteWriteSamplesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: collector.ExporterName,
+		Name:      "remote_write_samples_failed_total",
+		Help:      "number of samples that failed to be sent by remote write, or were dropped because the queue is full",
+	}, []string{"remote"})
+	remoteWriteSamplesPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: collector.ExporterName,
+		Name:      "remote_write_samples_pending",
+		Help:      "number of samples waiting to be sent by remote write",
+	}, []string{"remote"})
+)
+
+func RegisterCollector(reg prometheus.Registerer) {
+	reg.MustRegister(remoteWriteSamplesSent, remoteWriteSamplesFailed, remoteWriteSamplesPending)
+}
+
+type prompbLabel struct {
+	name, value string
+}
+
+type timeSeries struct {
+	labels    []prompbLabel
+	value     float64
+	timestamp int64
+}
+
+// encodeWriteRequest encodes the series as a prometheus.WriteRequest protobuf message.
+func encodeWriteRequest(series []timeSeries) []byte {
+	var buf, tsBuf, subBuf []byte
+	for _, ts := range series {
+		tsBuf = tsBuf[:0]
+		for _, l := range ts.labels {
+			subBuf = subBuf[:0]
+			subBuf = protowire.AppendTag(subBuf, 1, protowire.BytesType)
+			subBuf = protowire.AppendString(subBuf, l.name)
+			subBuf = protowire.AppendTag(subBuf, 2, protowire.BytesType)
+			subBuf = protowire.AppendString(subBuf, l.value)
+			tsBuf = protowire.AppendTag(tsBuf, 1, protowire.BytesType)
+			tsBuf = protowire.AppendBytes(tsBuf, subBuf)
+		}
+		subBuf = subBuf[:0]
+		subBuf = protowire.AppendTag(subBuf, 1, protowire.Fixed64Type)
+		subBuf = protowire.AppendFixed64(subBuf, math.Float64bits(ts.value))
+		subBuf = protowire.AppendTag(subBuf, 2, protowire.VarintType)
+		subBuf = protowire.AppendVarint(subBuf, uint64(ts.timestamp))
+		tsBuf = protowire.AppendTag(tsBuf, 2, protowire.BytesType)
+		tsBuf = protowire.AppendBytes(tsBuf, subBuf)
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, tsBuf)
+	}
+	return buf
+}
+
+// familiesToSeries converts the metric families to series, histograms and summaries are split into
+// their _bucket/_sum/_count series like in the text exposition format.
+func familiesToSeries(families []*dto.MetricFamily, externalLabels map[string]string, now time.Time) []timeSeries {
+	var series []timeSeries
+	collector.WalkSamples(families, func(_ *dto.MetricFamily, m *dto.Metric, name string, value float64, extra *dto.LabelPair) {
+		timestamp := now.UnixMilli()
+		if m.TimestampMs != nil {
+			timestamp = m.GetTimestampMs()
+		}
+		labels := make([]prompbLabel, 0, len(m.GetLabel())+len(externalLabels)+2)
+		labels = append(labels, prompbLabel{name: model.MetricNameLabel, value: name})
+		names := map[string]bool{}
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, prompbLabel{name: lp.GetName(), value: lp.GetValue()})
+			names[lp.GetName()] = true
+		}
+		if extra != nil {
+			labels = append(labels, prompbLabel{name: extra.GetName(), value: extra.GetValue()})
+		}
+		for name, value := range externalLabels {
+			if !names[name] {
+				labels = append(labels, prompbLabel{name: name, value: value})
+			}
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		series = append(series, timeSeries{labels: labels, value: value, timestamp: timestamp})
+	})
+	return series
+}
+
+// RemoteWriter pushes the metrics of all collects to the remote_write endpoints of the configuration.
+type RemoteWriter struct {
+	logger     log.Logger
+	safeConfig *config.SafeConfig
+}
+
+func NewRemoteWriter(logger log.Logger, sc *config.SafeConfig) *RemoteWriter {
+	return &RemoteWriter{logger: logger, safeConfig: sc}
+}
+
+// Run starts a queue for each remote_write of the configuration, the queues are restarted when the
+// configuration is reloaded. It returns when the context is done.
+func (w *RemoteWriter) Run(ctx context.Context) {
+	var current *config.Config
+	cancel := func() {}
+	wg := sync.WaitGroup{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if conf := w.safeConfig.GetConfig(); conf != current {
+			cancel()
+			wg.Wait()
+			current = conf
+			var queueCtx context.Context
+			queueCtx, cancel = context.WithCancel(ctx)
+			for _, rwConfig := range conf.RemoteWrite {
+				queue, err := newRemoteWriteQueue(log.With(w.logger, "remote_write", rwConfig.Name), rwConfig, conf.Collects)
+				if err != nil {
+					level.Error(w.logger).Log("msg", "failed to create remote write queue", "remote_write", rwConfig.Name, "err", err)
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					queue.run(queueCtx)
+				}()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			cancel()
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type remoteWriteQueue struct {
+	logger   log.Logger
+	config   *config.RemoteWriteConfig
+	collects collector.Collects
+	client   *http.Client
+	mux      sync.Mutex
+	pending  []timeSeries
+	// number of samples at the beginning of pending that are being sent
+	inflight int
+	notify   chan struct{}
+}
+
+func newRemoteWriteQueue(logger log.Logger, rwConfig *config.RemoteWriteConfig, collects collector.Collects) (*remoteWriteQueue, error) {
+	client, err := promconfig.NewClientFromConfig(rwConfig.HTTPClientConfig, "remote_write")
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = rwConfig.RemoteTimeout
+	return &remoteWriteQueue{
+		logger:   logger,
+		config:   rwConfig,
+		collects: collects,
+		client:   client,
+		notify:   make(chan struct{}, 1),
+	}, nil
+}
+
+func (q *remoteWriteQueue) run(ctx context.Context) {
+	defer remoteWriteSamplesPending.DeleteLabelValues(q.config.Name)
+	go q.sendLoop(ctx)
+	ticker := time.NewTicker(q.config.Interval)
+	defer ticker.Stop()
+	for {
+		q.gather(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// gather collects the metrics of all collects and appends them to the queue.
+func (q *remoteWriteQueue) gather(ctx context.Context) {
+	reg := prometheus.NewRegistry()
+	for idx := range q.collects {
+		if err := reg.Register(&collector.CollectContext{CollectConfig: &q.collects[idx], Context: ctx}); err != nil {
+			level.Error(q.logger).Log("msg", "failed to register collect", "collect", q.collects[idx].Name, "err", err)
+		}
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		level.Warn(q.logger).Log("msg", "error gathering metrics", "err", err)
+	}
+	series := familiesToSeries(families, q.config.ExternalLabels, time.Now())
+	if len(series) == 0 {
+		return
+	}
+	q.mux.Lock()
+	q.pending = append(q.pending, series...)
+	if dropped := len(q.pending) - q.config.QueueConfig.Capacity; dropped > 0 {
+		level.Warn(q.logger).Log("msg", "remote write queue is full, dropping the oldest samples", "dropped", dropped)
+		remoteWriteSamplesFailed.WithLabelValues(q.config.Name).Add(float64(dropped))
+		q.pending = append(q.pending[:q.inflight], q.pending[q.inflight+dropped:]...)
+	}
+	remoteWriteSamplesPending.WithLabelValues(q.config.Name).Set(float64(len(q.pending)))
+	q.mux.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *remoteWriteQueue) sendLoop(ctx context.Context) {
+	for {
+		q.mux.Lock()
+		batch := q.pending
+		if len(batch) > q.config.QueueConfig.MaxSamplesPerSend {
+			batch = batch[:q.config.QueueConfig.MaxSamplesPerSend]
+		}
+		q.inflight = len(batch)
+		q.mux.Unlock()
+		if len(batch) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.notify:
+				continue
+			}
+		}
+		err := q.sendWithRetry(ctx, batch)
+		if ctx.Err() != nil {
+			return
+		}
+		q.mux.Lock()
+		q.pending = q.pending[len(batch):]
+		q.inflight = 0
+		remoteWriteSamplesPending.WithLabelValues(q.config.Name).Set(float64(len(q.pending)))
+		q.mux.Unlock()
+		if err != nil {
+			level.Error(q.logger).Log("msg", "failed to send samples", "count", len(batch), "err", err)
+			remoteWriteSamplesFailed.WithLabelValues(q.config.Name).Add(float64(len(batch)))
+		} else {
+			remoteWriteSamplesSent.WithLabelValues(q.config.Name).Add(float64(len(batch)))
+		}
+	}
+}
+
+type recoverableError struct {
+	error
+}
+
+// sendWithRetry sends the samples, the request is retried with exponential backoff if it failed with a
+// recoverable error (network error, 5xx or 429 status code).
+func (q *remoteWriteQueue) sendWithRetry(ctx context.Context, series []timeSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	backoff := q.config.QueueConfig.MinBackoff
+	for try := 0; ; try++ {
+		err := q.send(ctx, body)
+		if _, ok := err.(recoverableError); !ok || try >= q.config.QueueConfig.MaxRetries {
+			return err
+		}
+		level.Warn(q.logger).Log("msg", "failed to send samples, retrying", "err", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > q.config.QueueConfig.MaxBackoff {
+			backoff = q.config.QueueConfig.MaxBackoff
+		}
+	}
+}
+
+func (q *remoteWriteQueue) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range q.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", collector.ExporterName+"/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 MicroOps
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/MicroOps-cn/data_exporter/config"
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// decodeWriteRequest decodes a prometheus.WriteRequest message into a map of series (labels in the
+// text format) to values.
+func decodeWriteRequest(t *testing.T, data []byte) map[string]float64 {
+	each := func(b []byte, f func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			require.True(t, n > 0)
+			b = b[n:]
+			n = f(num, typ, b)
+			require.True(t, n > 0)
+			b = b[n:]
+		}
+	}
+	result := map[string]float64{}
+	each(data, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		var labels []string
+		var value float64
+		each(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			sub, n := protowire.ConsumeBytes(b)
+			var fields [2]string
+			each(sub, func(num protowire.Number, typ protowire.Type, b []byte) int {
+				switch typ {
+				case protowire.BytesType:
+					v, n := protowire.ConsumeString(b)
+					fields[num-1] = v
+					return n
+				case protowire.Fixed64Type:
+					v, n := protowire.ConsumeFixed64(b)
+					value = math.Float64frombits(v)
+					return n
+				default:
+					_, n := protowire.ConsumeVarint(b)
+					return n
+				}
+			})
+			if num == 1 {
+				labels = append(labels, fields[0]+"="+fields[1])
+			}
+			return n
+		})
+		result[strings.Join(labels, ",")] = value
+		return n
+	})
+	return result
+}
+
+func TestRemoteWrite(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+		err    error
+	}
+	var requests int32
+	// the requests are checked by the test goroutine, t.FailNow cannot be called by the handler
+	received := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request fails with a recoverable error and is retried
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		received <- request{header: r.Header, body: body, err: err}
+	}))
+	defer server.Close()
+
+	dataPath := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(dataPath, []byte("# TYPE requests_total counter\nrequests_total{path=\"/\"} 3\n"), 0644))
+	sc := config.NewSafeConfig()
+	require.NoError(t, sc.ReloadConfigFromReader(io.NopCloser(strings.NewReader(fmt.Sprintf(`
+collects:
+  - name: prom
+    data_format: prometheus
+    datasource:
+      - type: file
+        url: %q
+    metrics:
+      - name: all
+remote_write:
+  - url: %s/api/v1/write
+    authorization:
+      credentials: secret
+    interval: 100ms
+    external_labels:
+      site: edge1
+    queue_config:
+      min_backoff: 10ms
+`, dataPath, server.URL))), log.NewNopLogger()))
+	rwConfig := sc.GetConfig().RemoteWrite[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewRemoteWriter(log.NewNopLogger(), sc).Run(ctx)
+	select {
+	case req := <-received:
+		require.NoError(t, req.err)
+		require.Equal(t, "snappy", req.header.Get("Content-Encoding"))
+		require.Equal(t, "Bearer secret", req.header.Get("Authorization"))
+		data, err := snappy.Decode(nil, req.body)
+		require.NoError(t, err)
+		require.Equal(t, map[string]float64{"__name__=requests_total,path=/,site=edge1": 3}, decodeWriteRequest(t, data))
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for remote write request")
+	}
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(remoteWriteSamplesSent.WithLabelValues(rwConfig.Name)) >= 1
+	}, time.Second*5, time.Millisecond*10)
+	require.Equal(t, float64(0), testutil.ToFloat64(remoteWriteSamplesFailed.WithLabelValues(rwConfig.Name)))
+}